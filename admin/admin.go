@@ -1,29 +1,205 @@
 package admin
 
 import (
+	"errors"
+	"fmt"
 	"opensavecloudserver/database"
 	"opensavecloudserver/upload"
+	"strings"
 )
 
+// ErrRoleNotAllowed is returned when an administrator give or define a role with permissions they do not have
+var ErrRoleNotAllowed = errors.New("this role has permissions that you do not have")
+
 // RemoveUser rome the user from the db and all his datas
 func RemoveUser(user *database.User) error {
+	if err := database.RemoveAllUserGameShares(user); err != nil {
+		return err
+	}
+	if err := database.RemoveAllUserGroupMemberships(user); err != nil {
+		return err
+	}
+	gameLinks, err := database.ShareLinksOfUserGames(user.ID)
+	if err != nil {
+		return err
+	}
+	if err := upload.RemoveLinkSnapshots(gameLinks); err != nil {
+		return err
+	}
 	if err := database.RemoveAllUserGameEntries(user); err != nil {
 		return err
 	}
+	if err := database.RemoveAllUserRefreshTokens(user); err != nil {
+		return err
+	}
+	if err := database.RemoveAllUserSessions(user); err != nil {
+		return err
+	}
+	if err := database.RemoveAllUserPersonalAccessTokens(user); err != nil {
+		return err
+	}
+	if err := database.RemoveAllUserRecoveryCodes(user); err != nil {
+		return err
+	}
+	if err := database.RemoveAllUserPasswordHistory(user); err != nil {
+		return err
+	}
+	if err := database.RemoveAllUserPasswordResets(user); err != nil {
+		return err
+	}
+	links, err := database.ShareLinksByUserId(user.ID)
+	if err != nil {
+		return err
+	}
+	for _, link := range links {
+		if err := upload.RemoveLinkSnapshot(link); err != nil {
+			return err
+		}
+		if err := database.RemoveShareLink(link); err != nil {
+			return err
+		}
+	}
 	if err := upload.RemoveFolders(user.ID); err != nil {
 		return err
 	}
 	return database.RemoveUser(user)
 }
 
-func SetAdmin(user *database.User) error {
-	user.Role = database.AdminRole
-	user.IsAdmin = true
+// SetStorageQuota change the storage quota in bytes of the user, a nil quota is an unlimited storage
+func SetStorageQuota(user *database.User, quota *int64) error {
+	if quota != nil && *quota < 0 {
+		return errors.New("the storage quota cannot be negative")
+	}
+	user.StorageQuota = quota
 	return database.SaveUser(user)
 }
 
+// SetAdmin give the admin role to the user, only an administrator that has all the permissions can do it
+func SetAdmin(actor *database.Role, user *database.User) error {
+	return SetRole(actor, user, database.AdminRole)
+}
+
 func RemoveAdminRole(user *database.User) error {
 	user.Role = database.UserRole
 	user.IsAdmin = false
 	return database.SaveUser(user)
 }
+
+// ResetTotp disable the two-factor authentication of the user and remove their recovery codes
+func ResetTotp(user *database.User) error {
+	user.TotpSecret = nil
+	user.TotpEnabled = false
+	user.TotpLastStep = 0
+	if err := database.RemoveAllUserRecoveryCodes(user); err != nil {
+		return err
+	}
+	return database.SaveUser(user)
+}
+
+// SetRole give a role of the database to the user, the administrator must have all the permissions of the role
+func SetRole(actor *database.Role, user *database.User, name string) error {
+	role, err := database.RoleByName(name)
+	if err != nil {
+		return fmt.Errorf("the role '%s' does not exist", name)
+	}
+	if err := checkRoleGrant(actor, role); err != nil {
+		return err
+	}
+	user.Role = role.Name
+	user.IsAdmin = role.Name == database.AdminRole
+	return database.SaveUser(user)
+}
+
+// SaveRole create or update a role, the built-in roles cannot be modified. The administrator must have all
+// the permissions of the role, before and after the update
+func SaveRole(actor *database.Role, role *database.Role) (*database.Role, error) {
+	role.Name = strings.TrimSpace(role.Name)
+	if len(role.Name) == 0 || len(role.Name) > 50 {
+		return nil, errors.New("the name of the role must be between 1 and 50 characters")
+	}
+	for _, permission := range role.PermissionList {
+		if !validPermission(permission) {
+			return nil, fmt.Errorf("the permission '%s' does not exist", permission)
+		}
+	}
+	if err := checkRoleGrant(actor, role); err != nil {
+		return nil, err
+	}
+	stored, err := database.RoleByName(role.Name)
+	if err == nil {
+		if stored.BuiltIn {
+			return nil, errors.New("a built-in role cannot be modified")
+		}
+		if err := checkRoleGrant(actor, stored); err != nil {
+			return nil, err
+		}
+		stored.Description = role.Description
+		stored.PermissionList = role.PermissionList
+		role = stored
+	} else {
+		role.ID = 0
+		role.BuiltIn = false
+	}
+	if role.PermissionList == nil {
+		role.PermissionList = []string{}
+	}
+	if err := database.SaveRole(role); err != nil {
+		return nil, err
+	}
+	return role, nil
+}
+
+// RemoveRole delete a role that is not built-in and not given to a user
+func RemoveRole(name string) error {
+	role, err := database.RoleByName(name)
+	if err != nil {
+		return err
+	}
+	if role.BuiltIn {
+		return errors.New("a built-in role cannot be removed")
+	}
+	count, err := database.CountUsersWithRole(role.Name)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("the role is given to %d users", count)
+	}
+	return database.RemoveRole(role)
+}
+
+// checkRoleGrant refuse a role that has a permission the administrator does not have, so a role manager
+// cannot give themselves or another user more permissions than they have. Only the administrators that have
+// all the permissions can use "*"
+func checkRoleGrant(actor, role *database.Role) error {
+	for _, permission := range role.PermissionList {
+		if permission == database.PermissionAll && !hasAllPermissions(actor) {
+			return ErrRoleNotAllowed
+		}
+	}
+	if !actor.Includes(role) {
+		return ErrRoleNotAllowed
+	}
+	return nil
+}
+
+func hasAllPermissions(role *database.Role) bool {
+	for _, permission := range role.PermissionList {
+		if permission == database.PermissionAll {
+			return true
+		}
+	}
+	return false
+}
+
+func validPermission(permission string) bool {
+	if permission == database.PermissionAll {
+		return true
+	}
+	for _, p := range database.Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
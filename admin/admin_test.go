@@ -0,0 +1,37 @@
+package admin
+
+import (
+	"errors"
+	"opensavecloudserver/database"
+	"testing"
+)
+
+func TestCheckRoleGrant(t *testing.T) {
+	roleManager := &database.Role{Name: "role_manager", PermissionList: []string{database.PermissionRoles, database.PermissionUsersRead}}
+	administrator := &database.Role{Name: database.AdminRole, PermissionList: []string{database.PermissionAll}}
+	tests := []struct {
+		name    string
+		actor   *database.Role
+		role    *database.Role
+		allowed bool
+	}{
+		{"role manager give the admin role", roleManager, administrator, false},
+		{"role manager define a role with every permission", roleManager, &database.Role{Name: "root", PermissionList: []string{database.PermissionAll}}, false},
+		{"role manager define a role with a permission they do not have", roleManager, &database.Role{Name: "auditor", PermissionList: []string{database.PermissionAudit}}, false},
+		{"role manager give their own permissions", roleManager, &database.Role{Name: "reader", PermissionList: []string{database.PermissionUsersRead}}, true},
+		{"role manager give a role without permissions", roleManager, &database.Role{Name: database.UserRole, PermissionList: []string{}}, true},
+		{"admin give the admin role", administrator, administrator, true},
+		{"admin define a role with every permission", administrator, &database.Role{Name: "root", PermissionList: []string{database.PermissionAll}}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := checkRoleGrant(test.actor, test.role)
+			if test.allowed && err != nil {
+				t.Fatalf("the role must be allowed, got %v", err)
+			}
+			if !test.allowed && !errors.Is(err, ErrRoleNotAllowed) {
+				t.Fatalf("the role must be refused with ErrRoleNotAllowed, got %v", err)
+			}
+		})
+	}
+}
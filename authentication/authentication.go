@@ -2,78 +2,303 @@ package authentication
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 	"log"
+	"opensavecloudserver/config"
 	"opensavecloudserver/database"
+	"sync"
+	"time"
 )
 
-var secret []byte
+var (
+	dummyHash     []byte
+	dummyHashOnce sync.Once
+)
 
 type AccessToken struct {
-	Token string `json:"token"`
+	Token        string    `json:"token"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	Expire       time.Time `json:"expire"`
+}
+
+// TokenInfo is the content of a valid access token. Scopes is nil for the tokens of a session,
+// they are not restricted
+type TokenInfo struct {
+	ID        string
+	SessionId string
+	UserId    int
+	IssuedAt  time.Time
+	Expire    time.Time
+	Scopes    []string
 }
 
 type Registration struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
+	Username       string `json:"username"`
+	Password       string `json:"password"`
+	InvitationCode string `json:"invitation_code"`
 }
 
-func init() {
-	secret = make([]byte, 512)
-	_, err := rand.Read(secret)
-	if err != nil {
+// Init load the signing keys and reload them every minute, so a new key can be rotated in
+// or an old one retired without restarting the server
+func Init() {
+	if err := loadKeys(); err != nil {
+		log.Fatal(err)
+	}
+	if err := loadBreachedPasswords(); err != nil {
 		log.Fatal(err)
 	}
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+			if err := loadKeys(); err != nil {
+				log.Println(err)
+			}
+			if err := database.RemoveExpiredRefreshTokens(); err != nil {
+				log.Println(err)
+			}
+			if err := database.RemoveExpiredSessions(); err != nil {
+				log.Println(err)
+			}
+			clearOidcRequests()
+			clearDeviceRequests()
+			clearAttempts()
+			clearShareLinks()
+		}
+	}()
+}
+
+// Connect check the credential of the user and open a session. When the two-factor authentication is enabled,
+// a challenge is returned instead of a token, it must be given to ConnectTotp with the TOTP code
+func Connect(username, password string, client ClientInfo) (*AccessToken, *TotpChallenge, error) {
+	if err := checkAttempts(username, client.Ip); err != nil {
+		return nil, nil, err
+	}
+	user, err := checkCredential(username, password)
+	if err != nil {
+		failedAttempt(username, client.Ip)
+		return nil, nil, err
+	}
+	if user.TotpEnabled {
+		challenge, err := totpChallenge(user)
+		if err != nil {
+			return nil, nil, err
+		}
+		return nil, challenge, nil
+	}
+	successfulAttempt(username)
+	session, err := newSession(user.ID, client)
+	if err != nil {
+		return nil, nil, err
+	}
+	token, err := issueTokens(session)
+	if err != nil {
+		return nil, nil, err
+	}
+	return token, nil, nil
 }
 
-func Connect(username, password string) (*AccessToken, error) {
+// checkCredential check the password of a local user. When LDAP is enabled, the users of the directory
+// and the unknown users are authenticated by the directory
+func checkCredential(username, password string) (*database.User, error) {
 	user, err := database.UserByUsername(username)
+	if err == nil && (user.LdapDn == nil || !config.Authentication().Ldap.Enabled) {
+		if err := bcrypt.CompareHashAndPassword(user.Password, []byte(password)); err != nil {
+			return nil, err
+		}
+		rehashPassword(user, password)
+		return user, nil
+	}
+	if !config.Authentication().Ldap.Enabled {
+		// Compare anyway, so an unknown user take as long as a wrong password
+		compareDummyHash(password)
+		return nil, err
+	}
+	identity, err := ldapAuthenticate(username, password)
+	if err != nil {
+		return nil, err
+	}
+	return ldapUser(user, username, identity)
+}
+
+// adminGroupRole give the role of a user of the directory or of the single sign-on from their membership of the
+// admin group. A member is promoted from user to admin and an admin who left the group is demoted to user,
+// the custom roles given by an administrator are kept
+func adminGroupRole(role string, admin bool) string {
+	if admin && role == database.UserRole {
+		return database.AdminRole
+	}
+	if !admin && role == database.AdminRole {
+		return database.UserRole
+	}
+	return role
+}
+
+// compareDummyHash spend the time of a password check
+func compareDummyHash(password string) {
+	dummyHashOnce.Do(func() {
+		hash, err := hashPassword("dummy password")
+		if err != nil {
+			log.Println(err)
+		}
+		dummyHash = hash
+	})
+	_ = bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+}
+
+// Refresh exchange a refresh token for a new access token. The refresh token can only be used once,
+// a new one is given with the access token
+func Refresh(refreshToken string, client ClientInfo) (*AccessToken, error) {
+	storedToken, err := database.RefreshTokenByHash(hashToken(refreshToken))
 	if err != nil {
 		return nil, err
 	}
-	if err := bcrypt.CompareHashAndPassword(user.Password, []byte(password)); err != nil {
+	if err := database.RemoveRefreshToken(storedToken); err != nil {
+		if errors.Is(err, database.ErrRefreshTokenUsed) {
+			// the token was used by another request, it can be stolen so the session is revoked
+			if err := Logout(storedToken.SessionId); err != nil {
+				log.Println(err)
+			}
+		}
 		return nil, err
 	}
-	token, err := token(user.ID)
+	now := time.Now()
+	if storedToken.Expire.Before(now) {
+		return nil, errors.New("the refresh token is expired")
+	}
+	session, err := database.SessionById(storedToken.SessionId)
 	if err != nil {
 		return nil, err
 	}
-	return &AccessToken{
-		Token: token,
-	}, nil
+	session.Ip = client.Ip
+	session.LastSeen = now
+	session.Expire = now.Add(config.Authentication().RefreshTokenLifetime)
+	if err := database.SaveSession(session); err != nil {
+		return nil, err
+	}
+	return issueTokens(session)
 }
 
 func ParseToken(token string) (int, error) {
-	var claims jwt.MapClaims
-	_, err := jwt.ParseWithClaims(token, &claims, func(token *jwt.Token) (interface{}, error) {
-		return secret, nil
-	})
+	info, err := ParseTokenInfo(token)
 	if err != nil {
 		return 0, err
 	}
-	if userId, ok := claims["sub"]; ok {
-		return int(userId.(float64)), nil
+	return info.UserId, nil
+}
+
+// ParseTokenInfo check the token and its session, then return the information it contains
+func ParseTokenInfo(token string) (*TokenInfo, error) {
+	var claims jwt.MapClaims
+	_, err := jwt.ParseWithClaims(token, &claims, verificationKey)
+	if err != nil {
+		return nil, err
+	}
+	userId, ok := claims["sub"].(float64)
+	if !ok {
+		return nil, errors.New("this token does not have a userId in it")
+	}
+	expire, ok := claims["exp"].(float64)
+	if !ok {
+		return nil, errors.New("this token does not have an expiration date")
+	}
+	info := &TokenInfo{
+		UserId: int(userId),
+		Expire: time.Unix(int64(expire), 0),
+	}
+	if issuedAt, ok := claims["iat"].(float64); ok {
+		info.IssuedAt = time.Unix(int64(issuedAt), 0)
+	}
+	if id, ok := claims["jti"].(string); ok {
+		info.ID = id
+	}
+	sessionId, ok := claims["sid"].(string)
+	if !ok {
+		return nil, errors.New("this token does not have a session")
+	}
+	info.SessionId = sessionId
+	if err := checkSession(info); err != nil {
+		return nil, err
 	}
-	return 0, errors.New("this token does not have a userId in it")
+	return info, nil
 }
 
 func Register(user *Registration) error {
+	if err := checkRegistration(user); err != nil {
+		return err
+	}
+	hash, err := hashPassword(user.Password)
+	if err != nil {
+		return err
+	}
+	return database.AddUser(user.Username, hash)
+}
+
+// checkRegistration check the username and the password of a new user
+func checkRegistration(user *Registration) error {
+	if len(user.Username) < 3 {
+		return errors.New("username need at least 3 characters")
+	}
 	_, err := database.UserByUsername(user.Username)
 	if err == nil {
 		return errors.New("this username already exist")
 	}
-	hash, err := bcrypt.GenerateFromPassword([]byte(user.Password), 12)
+	return CheckPasswordPolicy(user.Password)
+}
+
+// issueTokens create a new access token and its refresh token for the session
+func issueTokens(session *database.Session) (*AccessToken, error) {
+	now := time.Now()
+	expire := now.Add(config.Authentication().AccessTokenLifetime)
+	accessToken, err := token(session, now, expire)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return database.AddUser(user.Username, hash)
+	refreshToken, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	_, err = database.AddRefreshToken(session, hashToken(refreshToken), session.Expire)
+	if err != nil {
+		return nil, err
+	}
+	return &AccessToken{
+		Token:        accessToken,
+		RefreshToken: refreshToken,
+		Expire:       expire,
+	}, nil
 }
 
-func token(userId int) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS512, jwt.MapClaims{
-		"sub": userId,
+func token(session *database.Session, issuedAt, expire time.Time) (string, error) {
+	key := signingKeyForToken()
+	token := jwt.NewWithClaims(key.method, jwt.MapClaims{
+		"sub": session.UserId,
+		"sid": session.ID,
+		"iat": issuedAt.Unix(),
+		"exp": expire.Unix(),
+		"jti": uuid.New().String(),
 	})
-	return token.SignedString(secret)
+	token.Header["kid"] = key.id
+	return token.SignedString(key.private)
+}
+
+// randomToken generate an opaque token, used for the refresh and personal access tokens
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken give the hash of an opaque token, only this hash is stored in the database
+func hashToken(value string) string {
+	h := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(h[:])
 }
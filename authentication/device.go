@@ -0,0 +1,245 @@
+package authentication
+
+import (
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	deviceRequestLifetime = 10 * time.Minute
+	devicePollInterval    = 5 * time.Second
+	// userCodeAlphabet does not have vowels and ambiguous characters, so a code is easy to type and never forms a word
+	userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+)
+
+var (
+	// ErrSlowDown is returned when the client poll faster than the interval
+	ErrSlowDown = errors.New("slow_down")
+	// ErrAccessDenied is returned when the user refused the authorization of the device
+	ErrAccessDenied = errors.New("access_denied")
+	// ErrExpiredToken is returned when the device code does not exist or is expired
+	ErrExpiredToken = errors.New("expired_token")
+)
+
+// DeviceAuthorization is given to the device, the user code is shown to the user and the device
+// poll DeviceToken with the device code
+type DeviceAuthorization struct {
+	DeviceCode string    `json:"device_code"`
+	UserCode   string    `json:"user_code"`
+	Expire     time.Time `json:"expire"`
+	ExpiresIn  int       `json:"expires_in"`
+	Interval   int       `json:"interval"`
+}
+
+// DeviceRequest is the pending authorization shown to the user before the approval
+type DeviceRequest struct {
+	UserCode string    `json:"user_code"`
+	Device   string    `json:"device"`
+	Ip       string    `json:"ip"`
+	Expire   time.Time `json:"expire"`
+}
+
+type deviceRequest struct {
+	deviceCode string
+	userCode   string
+	client     ClientInfo
+	expire     time.Time
+	lastPoll   time.Time
+	// userId is the user who approved or denied the request, only this user can act on it again
+	userId   int
+	approved bool
+	denied   bool
+}
+
+var (
+	deviceRequests = make(map[string]*deviceRequest)
+	deviceMu       sync.Mutex
+)
+
+// DeviceAuthorize create a device authorization request
+func DeviceAuthorize(client ClientInfo) (*DeviceAuthorization, error) {
+	deviceCode, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	deviceMu.Lock()
+	defer deviceMu.Unlock()
+	var userCode string
+	for {
+		userCode, err = newUserCode()
+		if err != nil {
+			return nil, err
+		}
+		if requestByUserCode(userCode) == nil {
+			break
+		}
+	}
+	request := &deviceRequest{
+		deviceCode: deviceCode,
+		userCode:   userCode,
+		client:     client,
+		expire:     time.Now().Add(deviceRequestLifetime),
+	}
+	deviceRequests[deviceCode] = request
+	return &DeviceAuthorization{
+		DeviceCode: deviceCode,
+		UserCode:   userCode,
+		Expire:     request.expire,
+		ExpiresIn:  int(deviceRequestLifetime.Seconds()),
+		Interval:   int(devicePollInterval.Seconds()),
+	}, nil
+}
+
+// PendingDevice give the information of the device that requested the user code.
+// The wrong codes are counted as failed attempts of the IP, so the codes cannot be guessed
+func PendingDevice(userCode, ip string) (*DeviceRequest, error) {
+	if err := checkIpAttempts(ip); err != nil {
+		return nil, err
+	}
+	deviceMu.Lock()
+	defer deviceMu.Unlock()
+	request := requestByUserCode(normalizeUserCode(userCode))
+	if request == nil || request.userId != 0 {
+		failedIpAttempt(ip)
+		return nil, errors.New("this code does not exist or is expired")
+	}
+	return &DeviceRequest{
+		UserCode: request.userCode,
+		Device:   request.client.Device,
+		Ip:       request.client.Ip,
+		Expire:   request.expire,
+	}, nil
+}
+
+// ApproveDevice authorize the device of the user code to open a session for the user
+func ApproveDevice(userId int, userCode, ip string) error {
+	if err := checkIpAttempts(ip); err != nil {
+		return err
+	}
+	deviceMu.Lock()
+	defer deviceMu.Unlock()
+	request, err := requestOfUser(userId, userCode, ip)
+	if err != nil {
+		return err
+	}
+	if request.denied {
+		return errors.New("this code was already denied")
+	}
+	request.userId = userId
+	request.approved = true
+	return nil
+}
+
+// DenyDevice refuse the authorization of the device of the user code, the user who approved
+// the code can still deny it while the device did not get its token
+func DenyDevice(userId int, userCode, ip string) error {
+	if err := checkIpAttempts(ip); err != nil {
+		return err
+	}
+	deviceMu.Lock()
+	defer deviceMu.Unlock()
+	request, err := requestOfUser(userId, userCode, ip)
+	if err != nil {
+		return err
+	}
+	request.userId = userId
+	request.approved = false
+	request.denied = true
+	return nil
+}
+
+// requestOfUser find the request of the user code that is still free or that the user already acted on,
+// deviceMu must be locked
+func requestOfUser(userId int, userCode, ip string) (*deviceRequest, error) {
+	request := requestByUserCode(normalizeUserCode(userCode))
+	if request == nil || (request.userId != 0 && request.userId != userId) {
+		failedIpAttempt(ip)
+		return nil, errors.New("this code does not exist or is expired")
+	}
+	return request, nil
+}
+
+// DeviceToken give the token to the device once the user approved the request
+func DeviceToken(deviceCode string) (*AccessToken, error) {
+	deviceMu.Lock()
+	request, ok := deviceRequests[deviceCode]
+	if !ok || request.expire.Before(time.Now()) {
+		deviceMu.Unlock()
+		return nil, ErrExpiredToken
+	}
+	now := time.Now()
+	if now.Sub(request.lastPoll) < devicePollInterval {
+		request.lastPoll = now
+		deviceMu.Unlock()
+		return nil, ErrSlowDown
+	}
+	request.lastPoll = now
+	if request.denied {
+		delete(deviceRequests, deviceCode)
+		deviceMu.Unlock()
+		return nil, ErrAccessDenied
+	}
+	if !request.approved {
+		deviceMu.Unlock()
+		return nil, ErrAuthorizationPending
+	}
+	delete(deviceRequests, deviceCode)
+	deviceMu.Unlock()
+	session, err := newSession(request.userId, request.client)
+	if err != nil {
+		return nil, err
+	}
+	return issueTokens(session)
+}
+
+// clearDeviceRequests remove the device requests that are expired
+func clearDeviceRequests() {
+	deviceMu.Lock()
+	defer deviceMu.Unlock()
+	now := time.Now()
+	for deviceCode, request := range deviceRequests {
+		if request.expire.Before(now) {
+			delete(deviceRequests, deviceCode)
+		}
+	}
+}
+
+// requestByUserCode find a request that is not expired, deviceMu must be locked
+func requestByUserCode(userCode string) *deviceRequest {
+	now := time.Now()
+	for _, request := range deviceRequests {
+		if request.userCode == userCode && request.expire.After(now) {
+			return request
+		}
+	}
+	return nil
+}
+
+func newUserCode() (string, error) {
+	var sb strings.Builder
+	max := big.NewInt(int64(len(userCodeAlphabet)))
+	for i := 0; i < 8; i++ {
+		if i == 4 {
+			sb.WriteByte('-')
+		}
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		sb.WriteByte(userCodeAlphabet[n.Int64()])
+	}
+	return sb.String(), nil
+}
+
+func normalizeUserCode(userCode string) string {
+	userCode = strings.ToUpper(strings.TrimSpace(userCode))
+	userCode = strings.ReplaceAll(userCode, "-", "")
+	if len(userCode) == 8 {
+		userCode = userCode[:4] + "-" + userCode[4:]
+	}
+	return userCode
+}
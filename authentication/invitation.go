@@ -0,0 +1,145 @@
+package authentication
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"opensavecloudserver/config"
+	"opensavecloudserver/database"
+	"strings"
+	"time"
+)
+
+type NewInvitation struct {
+	Note         string     `json:"note"`
+	Role         string     `json:"role"`
+	MaxUses      *int       `json:"max_uses"`
+	StorageQuota *int64     `json:"storage_quota"`
+	Expire       *time.Time `json:"expire"`
+}
+
+// CreatedInvitation is the only time the clear code of the invitation is given
+type CreatedInvitation struct {
+	*database.Invitation
+	Code string `json:"code"`
+}
+
+// CreateInvitation generate an invitation code. Without max uses, the code can be used once,
+// a max uses of 0 allow an unlimited number of registrations. The role of the invitation cannot
+// give more permissions than the role of the administrator. Without storage quota, the storage is unlimited
+func CreateInvitation(adminId int, info *NewInvitation) (*CreatedInvitation, error) {
+	role := info.Role
+	if len(role) == 0 {
+		role = database.UserRole
+	}
+	invitationRole, err := database.RoleByName(role)
+	if err != nil {
+		return nil, fmt.Errorf("the role '%s' does not exist", role)
+	}
+	admin, err := database.UserById(adminId)
+	if err != nil {
+		return nil, err
+	}
+	adminRole, err := database.RoleByName(admin.Role)
+	if err != nil {
+		return nil, err
+	}
+	if !adminRole.Includes(invitationRole) {
+		return nil, fmt.Errorf("the role '%s' has permissions that you do not have", role)
+	}
+	maxUses := 1
+	if info.MaxUses != nil {
+		maxUses = *info.MaxUses
+	}
+	if maxUses < 0 {
+		return nil, errors.New("the max uses cannot be negative")
+	}
+	if info.StorageQuota != nil && *info.StorageQuota < 0 {
+		return nil, errors.New("the storage quota cannot be negative")
+	}
+	if info.Expire != nil && info.Expire.Before(time.Now()) {
+		return nil, errors.New("the expiration date is in the past")
+	}
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	code := base32.StdEncoding.EncodeToString(b)
+	code = code[:4] + "-" + code[4:8] + "-" + code[8:12] + "-" + code[12:]
+	invitation := &database.Invitation{
+		Hash:         hashToken(normalizeInvitationCode(code)),
+		Note:         info.Note,
+		Role:         role,
+		MaxUses:      maxUses,
+		StorageQuota: info.StorageQuota,
+		Expire:       info.Expire,
+		CreatedBy:    adminId,
+		CreatedAt:    time.Now(),
+	}
+	if err := database.AddInvitation(invitation); err != nil {
+		return nil, err
+	}
+	return &CreatedInvitation{
+		Invitation: invitation,
+		Code:       code,
+	}, nil
+}
+
+func Invitations() ([]*database.Invitation, error) {
+	return database.AllInvitations()
+}
+
+// RevokeInvitation disable the invitation, it is kept to know who registered with it
+func RevokeInvitation(invitationId int) error {
+	invitation, err := database.InvitationById(invitationId)
+	if err != nil {
+		return err
+	}
+	invitation.Revoked = true
+	return database.SaveInvitation(invitation)
+}
+
+// RegisterWithInvitation register a user from the public registration. When the invitations are required,
+// the code is checked and the user is created with the role and the storage quota of the invitation
+func RegisterWithInvitation(registration *Registration) error {
+	if !config.Features().RequireInvitation {
+		return Register(registration)
+	}
+	invitation, err := database.InvitationByHash(hashToken(normalizeInvitationCode(registration.InvitationCode)))
+	if err != nil {
+		return errors.New("this invitation code is not valid")
+	}
+	if invitation.Revoked || (invitation.Expire != nil && invitation.Expire.Before(time.Now())) {
+		return errors.New("this invitation code is expired")
+	}
+	if invitation.MaxUses > 0 && invitation.Uses >= invitation.MaxUses {
+		return errors.New("this invitation code has already been used")
+	}
+	if err := checkRegistration(registration); err != nil {
+		return err
+	}
+	hash, err := hashPassword(registration.Password)
+	if err != nil {
+		return err
+	}
+	user := &database.User{
+		Username:     registration.Username,
+		Password:     hash,
+		Role:         invitation.Role,
+		StorageQuota: invitation.StorageQuota,
+	}
+	if err := database.AddInvitedUser(user, invitation); err != nil {
+		if errors.Is(err, database.ErrInvitationUsed) {
+			return errors.New("this invitation code has already been used")
+		}
+		return err
+	}
+	return nil
+}
+
+func normalizeInvitationCode(code string) string {
+	code = strings.ToUpper(code)
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ReplaceAll(code, " ", "")
+}
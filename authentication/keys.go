@@ -0,0 +1,276 @@
+package authentication
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt"
+	"log"
+	"opensavecloudserver/config"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// signingKey is a key loaded from the key store. A key without private part can only be used to verify
+// tokens, this is how a retired key is kept valid until all the tokens signed with it are gone
+type signingKey struct {
+	id      string
+	method  jwt.SigningMethod
+	private interface{}
+	public  interface{}
+	modTime time.Time
+}
+
+var (
+	keys       map[string]*signingKey
+	currentKey *signingKey
+	keysMu     sync.RWMutex
+)
+
+// loadKeys read the key store and select the key used to sign the new tokens
+func loadKeys() error {
+	authConfig := config.Authentication()
+	if len(authConfig.Keys) == 0 {
+		return useEphemeralKey()
+	}
+	info, err := os.Stat(authConfig.Keys)
+	if err != nil {
+		return err
+	}
+	var files []string
+	if info.IsDir() {
+		entries, err := os.ReadDir(authConfig.Keys)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				continue
+			}
+			files = append(files, filepath.Join(authConfig.Keys, entry.Name()))
+		}
+	} else {
+		files = append(files, authConfig.Keys)
+	}
+	loaded := make(map[string]*signingKey)
+	for _, file := range files {
+		key, err := readKeyFile(file)
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		if key != nil {
+			loaded[key.id] = key
+		}
+	}
+	if info.IsDir() && !hasPrivateKey(loaded) {
+		key, err := generateKey(authConfig.Keys)
+		if err != nil {
+			return err
+		}
+		log.Printf("no signing key found, a new Ed25519 key '%s' was generated", key.id)
+		loaded[key.id] = key
+	}
+	current, err := selectSigningKey(loaded, authConfig.SigningKey)
+	if err != nil {
+		return err
+	}
+	keysMu.Lock()
+	defer keysMu.Unlock()
+	keys = loaded
+	currentKey = current
+	return nil
+}
+
+// useEphemeralKey generate a random secret that only live until the server stop
+func useEphemeralKey() error {
+	keysMu.Lock()
+	defer keysMu.Unlock()
+	if currentKey != nil {
+		return nil
+	}
+	log.Println("authentication.keys is not set, tokens will be invalidated when the server restart")
+	secret := make([]byte, 512)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return err
+	}
+	currentKey = &signingKey{
+		id:      "ephemeral",
+		method:  jwt.SigningMethodHS512,
+		private: secret,
+		public:  secret,
+		modTime: time.Now(),
+	}
+	keys = map[string]*signingKey{currentKey.id: currentKey}
+	return nil
+}
+
+// readKeyFile parse a key file, the file extension give the format of the key.
+// '.pem' files contain an Ed25519 or RSA key, '.secret' files contain a raw HMAC secret
+func readKeyFile(path string) (*signingKey, error) {
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext != ".pem" && ext != ".secret" {
+		return nil, nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key := &signingKey{
+		id:      strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)),
+		modTime: info.ModTime(),
+	}
+	if ext == ".secret" {
+		secret := []byte(strings.TrimSpace(string(content)))
+		if len(secret) < 64 {
+			return nil, errors.New("the HMAC secret must be at least 64 bytes long")
+		}
+		key.method = jwt.SigningMethodHS512
+		key.private = secret
+		key.public = secret
+		return key, nil
+	}
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, errors.New("the file is not PEM encoded")
+	}
+	var parsed interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block '%s'", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch k := parsed.(type) {
+	case ed25519.PrivateKey:
+		key.method = jwt.SigningMethodEdDSA
+		key.private = k
+		key.public = k.Public()
+	case ed25519.PublicKey:
+		key.method = jwt.SigningMethodEdDSA
+		key.public = k
+	case *rsa.PrivateKey:
+		key.method = jwt.SigningMethodRS256
+		key.private = k
+		key.public = &k.PublicKey
+	case *rsa.PublicKey:
+		key.method = jwt.SigningMethodRS256
+		key.public = k
+	default:
+		return nil, errors.New("only Ed25519 and RSA keys are supported")
+	}
+	return key, nil
+}
+
+// generateKey create a new Ed25519 key in the key store directory
+func generateKey(dir string) (*signingKey, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, err
+	}
+	id := strconv.FormatInt(time.Now().Unix(), 10)
+	f, err := os.OpenFile(filepath.Join(dir, id+".pem"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	defer func(f *os.File) {
+		err := f.Close()
+		if err != nil {
+			log.Println(err)
+		}
+	}(f)
+	if err := pem.Encode(f, &pem.Block{Type: "PRIVATE KEY", Bytes: der}); err != nil {
+		return nil, err
+	}
+	return &signingKey{
+		id:      id,
+		method:  jwt.SigningMethodEdDSA,
+		private: private,
+		public:  public,
+		modTime: time.Now(),
+	}, nil
+}
+
+func hasPrivateKey(loaded map[string]*signingKey) bool {
+	for _, key := range loaded {
+		if key.private != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// selectSigningKey return the configured key or, if none is configured, the most recent private key
+func selectSigningKey(loaded map[string]*signingKey, id string) (*signingKey, error) {
+	if len(id) > 0 {
+		key, ok := loaded[id]
+		if !ok {
+			return nil, fmt.Errorf("the signing key '%s' does not exist", id)
+		}
+		if key.private == nil {
+			return nil, fmt.Errorf("the signing key '%s' is a public key", id)
+		}
+		return key, nil
+	}
+	var current *signingKey
+	for _, key := range loaded {
+		if key.private == nil {
+			continue
+		}
+		if current == nil || key.modTime.After(current.modTime) {
+			current = key
+		}
+	}
+	if current == nil {
+		return nil, errors.New("no private key available to sign the tokens")
+	}
+	return current, nil
+}
+
+// signingKeyForToken give the key that must be used to sign a new token
+func signingKeyForToken() *signingKey {
+	keysMu.RLock()
+	defer keysMu.RUnlock()
+	return currentKey
+}
+
+// verificationKey is the jwt.Keyfunc that find the key matching the 'kid' header of a token
+func verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, ok := token.Header["kid"].(string)
+	if !ok {
+		return nil, errors.New("the token does not have a key id")
+	}
+	keysMu.RLock()
+	defer keysMu.RUnlock()
+	key, ok := keys[kid]
+	if !ok {
+		return nil, errors.New("the key of this token is unknown or retired")
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, errors.New("the token algorithm does not match the key")
+	}
+	return key.public, nil
+}
@@ -0,0 +1,152 @@
+package authentication
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/go-ldap/ldap/v3"
+	"net/url"
+	"opensavecloudserver/config"
+	"opensavecloudserver/database"
+	"strings"
+)
+
+// ldapConnection is the part of the LDAP client used by the authentication
+type ldapConnection interface {
+	Bind(username, password string) error
+	Search(request *ldap.SearchRequest) (*ldap.SearchResult, error)
+	Close()
+}
+
+// ldapIdentity is the user found in the directory
+type ldapIdentity struct {
+	dn    string
+	admin bool
+}
+
+// dialLdap open a connection to the directory. It is a variable so the directory can be replaced
+// by an in-process implementation of ldapConnection
+var dialLdap = func(ldapConfig *config.LdapConfiguration) (ldapConnection, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: ldapConfig.InsecureSkipVerify,
+	}
+	if u, err := url.Parse(ldapConfig.Url); err == nil {
+		tlsConfig.ServerName = u.Hostname()
+	}
+	conn, err := ldap.DialURL(ldapConfig.Url, ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, err
+	}
+	if ldapConfig.StartTls {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// ldapAuthenticate search the user in the directory, then bind with their password
+func ldapAuthenticate(username, password string) (*ldapIdentity, error) {
+	ldapConfig := &config.Authentication().Ldap
+	if len(username) == 0 || len(password) == 0 {
+		// An empty password is an unauthenticated bind, the directory would accept it
+		return nil, errors.New("the username and the password are required")
+	}
+	conn, err := dialLdap(ldapConfig)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if len(ldapConfig.BindDn) > 0 {
+		if err := conn.Bind(ldapConfig.BindDn, ldapConfig.BindPassword); err != nil {
+			return nil, fmt.Errorf("the service account cannot bind: %w", err)
+		}
+	}
+	result, err := conn.Search(ldap.NewSearchRequest(
+		ldapConfig.BaseDn,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 0, false,
+		fmt.Sprintf(ldapConfig.UserFilter, ldap.EscapeFilter(username)),
+		[]string{"dn", "memberOf"},
+		nil,
+	))
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Entries) != 1 {
+		return nil, errors.New("the user does not exist in the directory or is not unique")
+	}
+	entry := result.Entries[0]
+	if err := conn.Bind(entry.DN, password); err != nil {
+		return nil, err
+	}
+	identity := &ldapIdentity{
+		dn: entry.DN,
+	}
+	if len(ldapConfig.AdminGroup) == 0 {
+		return identity, nil
+	}
+	for _, group := range entry.GetAttributeValues("memberOf") {
+		if strings.EqualFold(group, ldapConfig.AdminGroup) {
+			identity.admin = true
+			return identity, nil
+		}
+	}
+	if len(ldapConfig.GroupFilter) > 0 {
+		if len(ldapConfig.BindDn) > 0 {
+			if err := conn.Bind(ldapConfig.BindDn, ldapConfig.BindPassword); err != nil {
+				return nil, fmt.Errorf("the service account cannot bind: %w", err)
+			}
+		}
+		groups, err := conn.Search(ldap.NewSearchRequest(
+			ldapConfig.GroupBaseDn,
+			ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+			fmt.Sprintf(ldapConfig.GroupFilter, ldap.EscapeFilter(entry.DN)),
+			[]string{"dn"},
+			nil,
+		))
+		if err != nil {
+			return nil, err
+		}
+		for _, group := range groups.Entries {
+			if strings.EqualFold(group.DN, ldapConfig.AdminGroup) {
+				identity.admin = true
+				break
+			}
+		}
+	}
+	return identity, nil
+}
+
+// ldapUser give the local user of the directory identity, the user is created on their first login
+func ldapUser(user *database.User, username string, identity *ldapIdentity) (*database.User, error) {
+	ldapConfig := &config.Authentication().Ldap
+	if user == nil {
+		if !ldapConfig.AutoProvision {
+			return nil, errors.New("this user does not exist on the server")
+		}
+		// The password of the directory is never stored, the local password is a random one
+		password, err := randomToken()
+		if err != nil {
+			return nil, err
+		}
+		hash, err := hashPassword(password)
+		if err != nil {
+			return nil, err
+		}
+		user = &database.User{
+			Username: username,
+			Password: hash,
+			Role:     database.UserRole,
+		}
+	}
+	user.LdapDn = &identity.dn
+	if len(ldapConfig.AdminGroup) > 0 {
+		user.Role = adminGroupRole(user.Role, identity.admin)
+		user.IsAdmin = user.Role == database.AdminRole
+	}
+	if err := database.SaveUser(user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
@@ -0,0 +1,163 @@
+package authentication
+
+import (
+	"errors"
+	"github.com/go-ldap/ldap/v3"
+	"opensavecloudserver/config"
+	"opensavecloudserver/database"
+	"strings"
+	"testing"
+)
+
+const (
+	ldapServiceDn       = "cn=osc,ou=services,dc=example,dc=com"
+	ldapServicePassword = "service password"
+	ldapAdminGroup      = "cn=osc-admins,ou=groups,dc=example,dc=com"
+)
+
+// mockDirectory is an in-process directory, the users are found by their uid
+type mockDirectory struct {
+	passwords map[string]string
+	users     map[string]*ldap.Entry
+	groups    map[string][]string
+}
+
+// mockLdapConnection is a connection to the mock directory
+type mockLdapConnection struct {
+	directory *mockDirectory
+	bound     string
+}
+
+func (c *mockLdapConnection) Bind(username, password string) error {
+	if p, ok := c.directory.passwords[username]; !ok || len(password) == 0 || p != password {
+		return ldap.NewError(ldap.LDAPResultInvalidCredentials, errors.New("invalid credentials"))
+	}
+	c.bound = username
+	return nil
+}
+
+func (c *mockLdapConnection) Search(request *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	if c.bound != ldapServiceDn {
+		return nil, ldap.NewError(ldap.LDAPResultInsufficientAccessRights, errors.New("the search needs the service account"))
+	}
+	result := new(ldap.SearchResult)
+	if uid := filterValue(request.Filter, "uid"); len(uid) > 0 {
+		if entry, ok := c.directory.users[uid]; ok {
+			result.Entries = append(result.Entries, entry)
+		}
+	}
+	if member := filterValue(request.Filter, "member"); len(member) > 0 {
+		for group, members := range c.directory.groups {
+			for _, m := range members {
+				if m == member {
+					result.Entries = append(result.Entries, ldap.NewEntry(group, nil))
+				}
+			}
+		}
+	}
+	return result, nil
+}
+
+func (c *mockLdapConnection) Close() {}
+
+// filterValue give the value of a filter of a single attribute, like (uid=value)
+func filterValue(filter, attribute string) string {
+	prefix := "(" + attribute + "="
+	if !strings.HasPrefix(filter, prefix) || !strings.HasSuffix(filter, ")") {
+		return ""
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(filter, prefix), ")")
+}
+
+// stubLdap replace the directory of the configuration by the mock directory for the test
+func stubLdap(t *testing.T) {
+	directory := &mockDirectory{
+		passwords: map[string]string{
+			ldapServiceDn:                           ldapServicePassword,
+			"uid=alice,ou=people,dc=example,dc=com": "alice password",
+			"uid=bob,ou=people,dc=example,dc=com":   "bob password",
+			"uid=carol,ou=people,dc=example,dc=com": "carol password",
+		},
+		users: map[string]*ldap.Entry{
+			"alice": ldap.NewEntry("uid=alice,ou=people,dc=example,dc=com", map[string][]string{
+				"memberOf": {ldapAdminGroup},
+			}),
+			"bob": ldap.NewEntry("uid=bob,ou=people,dc=example,dc=com", nil),
+			"carol": ldap.NewEntry("uid=carol,ou=people,dc=example,dc=com", map[string][]string{
+				"memberOf": {"cn=players,ou=groups,dc=example,dc=com"},
+			}),
+		},
+		groups: map[string][]string{
+			ldapAdminGroup: {"uid=carol,ou=people,dc=example,dc=com"},
+		},
+	}
+	dial := dialLdap
+	dialLdap = func(ldapConfig *config.LdapConfiguration) (ldapConnection, error) {
+		return &mockLdapConnection{directory: directory}, nil
+	}
+	t.Cleanup(func() {
+		dialLdap = dial
+	})
+}
+
+func TestLdapAuthenticate(t *testing.T) {
+	stubLdap(t)
+	identity, err := ldapAuthenticate("bob", "bob password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.dn != "uid=bob,ou=people,dc=example,dc=com" {
+		t.Errorf("the DN is '%s'", identity.dn)
+	}
+	if identity.admin {
+		t.Error("a user outside of the admin group is an admin")
+	}
+}
+
+func TestLdapWrongPassword(t *testing.T) {
+	stubLdap(t)
+	if _, err := ldapAuthenticate("bob", "alice password"); err == nil {
+		t.Error("a wrong password is accepted")
+	}
+	if _, err := ldapAuthenticate("bob", ""); err == nil {
+		t.Error("an empty password is accepted")
+	}
+}
+
+func TestLdapUserNotFound(t *testing.T) {
+	stubLdap(t)
+	_, err := ldapAuthenticate("dave", "dave password")
+	if err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Errorf("an unknown user is not refused: %v", err)
+	}
+}
+
+func TestLdapAdminGroup(t *testing.T) {
+	stubLdap(t)
+	for username, admin := range map[string]bool{"alice": true, "bob": false, "carol": true} {
+		identity, err := ldapAuthenticate(username, username+" password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if identity.admin != admin {
+			t.Errorf("%s is admin: %t, expected %t", username, identity.admin, admin)
+		}
+	}
+	tests := []struct {
+		role     string
+		admin    bool
+		expected string
+	}{
+		{database.UserRole, true, database.AdminRole},
+		{database.UserRole, false, database.UserRole},
+		{database.AdminRole, true, database.AdminRole},
+		{database.AdminRole, false, database.UserRole},
+		{"user_manager", true, "user_manager"},
+		{"user_manager", false, "user_manager"},
+	}
+	for _, test := range tests {
+		if role := adminGroupRole(test.role, test.admin); role != test.expected {
+			t.Errorf("the role %s with admin %t give %s, expected %s", test.role, test.admin, role, test.expected)
+		}
+	}
+}
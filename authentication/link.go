@@ -0,0 +1,180 @@
+package authentication
+
+import (
+	"errors"
+	"github.com/golang-jwt/jwt"
+	"golang.org/x/crypto/bcrypt"
+	"log"
+	"opensavecloudserver/database"
+	"opensavecloudserver/upload"
+	"time"
+)
+
+const defaultShareLinkLifetime = 7 * 24 * time.Hour
+
+// ErrReadOnlyGame is returned when a user who can only read a game try to share it
+var ErrReadOnlyGame = errors.New("this game can only be read, it cannot be shared")
+
+// NewShareLink is the request to create a public link, without expiration the link is valid 7 days
+// and a max downloads of 0 allow an unlimited number of downloads
+type NewShareLink struct {
+	GameId       int        `json:"game_id"`
+	Password     string     `json:"password"`
+	MaxDownloads int        `json:"max_downloads"`
+	Expire       *time.Time `json:"expire"`
+}
+
+// CreatedShareLink is the only time the signed token of the link is given
+type CreatedShareLink struct {
+	*database.ShareLink
+	Token string `json:"token"`
+}
+
+// CreateShareLink create a public link to the current revision of a game save. The user must own the game
+// or be able to write it, a user who can only read it cannot publish it
+func CreateShareLink(userId int, info *NewShareLink) (*CreatedShareLink, error) {
+	game, err := database.AccessibleGameById(userId, info.GameId)
+	if err != nil {
+		return nil, err
+	}
+	if game.ShareLevel == database.ShareRead {
+		return nil, ErrReadOnlyGame
+	}
+	if !game.Available {
+		return nil, errors.New("the game does not have a save to share")
+	}
+	if info.MaxDownloads < 0 {
+		return nil, errors.New("the max downloads cannot be negative")
+	}
+	expire := time.Now().Add(defaultShareLinkLifetime)
+	if info.Expire != nil {
+		if info.Expire.Before(time.Now()) {
+			return nil, errors.New("the expiration date is in the past")
+		}
+		expire = *info.Expire
+	}
+	link := &database.ShareLink{
+		GameId:       game.ID,
+		UserId:       userId,
+		Revision:     game.Revision,
+		MaxDownloads: info.MaxDownloads,
+		Expire:       expire,
+		CreatedAt:    time.Now(),
+	}
+	if game.Hash != nil {
+		link.Hash = *game.Hash
+	}
+	if len(info.Password) > 0 {
+		link.Password, err = hashPassword(info.Password)
+		if err != nil {
+			return nil, err
+		}
+	}
+	link.PathStorage, err = upload.SnapshotSave(game)
+	if err != nil {
+		return nil, err
+	}
+	if err := database.AddShareLink(link); err != nil {
+		return nil, err
+	}
+	token, err := shareLinkToken(link)
+	if err != nil {
+		return nil, err
+	}
+	return &CreatedShareLink{
+		ShareLink: link,
+		Token:     token,
+	}, nil
+}
+
+// ShareLinks list the share links created by the user
+func ShareLinks(userId int) ([]*database.ShareLink, error) {
+	return database.ShareLinksByUserId(userId)
+}
+
+// RevokeShareLink remove a share link and its snapshot. The link can be revoked by the user who created it,
+// by the owner of the game or by an admin of the group of the game
+func RevokeShareLink(userId, linkId int) error {
+	link, err := database.ShareLinkById(linkId)
+	if err != nil {
+		return errors.New("this share link does not exist")
+	}
+	if link.UserId != userId {
+		game, err := database.AccessibleGameById(userId, link.GameId)
+		if err != nil || !database.CanManageGame(userId, game) {
+			return errors.New("this share link does not exist")
+		}
+	}
+	return removeShareLink(link)
+}
+
+// OpenShareLink check the signature of the token, the password and the downloads of the link, then count
+// the download. The link is returned so its snapshot can be sent. The creator of the link must still be able
+// to write the game, so the links of a user whose share or group was removed cannot be downloaded anymore
+func OpenShareLink(token, password, ip string) (*database.ShareLink, error) {
+	var claims jwt.MapClaims
+	_, err := jwt.ParseWithClaims(token, &claims, verificationKey)
+	if err != nil {
+		return nil, err
+	}
+	if use, ok := claims["use"].(string); !ok || use != "share" {
+		return nil, errors.New("this token is not a share link")
+	}
+	linkId, ok := claims["sub"].(float64)
+	if !ok {
+		return nil, errors.New("this token does not have a link in it")
+	}
+	link, err := database.ShareLinkById(int(linkId))
+	if err != nil || link.Expire.Before(time.Now()) {
+		return nil, errors.New("this share link does not exist or is expired")
+	}
+	if game, err := database.AccessibleGameById(link.UserId, link.GameId); err != nil || game.ShareLevel == database.ShareRead {
+		return nil, errors.New("this share link does not exist or is expired")
+	}
+	if link.HasPassword {
+		if err := checkIpAttempts(ip); err != nil {
+			return nil, err
+		}
+		if err := bcrypt.CompareHashAndPassword(link.Password, []byte(password)); err != nil {
+			failedIpAttempt(ip)
+			return nil, errors.New("the password of the share link is not valid")
+		}
+	}
+	if err := database.UseShareLink(link); err != nil {
+		return nil, errors.New("this share link reached its max downloads")
+	}
+	return link, nil
+}
+
+// shareLinkToken sign the ID of the link, the token expire with the link
+func shareLinkToken(link *database.ShareLink) (string, error) {
+	key := signingKeyForToken()
+	token := jwt.NewWithClaims(key.method, jwt.MapClaims{
+		"sub": link.ID,
+		"use": "share",
+		"exp": link.Expire.Unix(),
+	})
+	token.Header["kid"] = key.id
+	return token.SignedString(key.private)
+}
+
+func removeShareLink(link *database.ShareLink) error {
+	if err := upload.RemoveLinkSnapshot(link); err != nil {
+		return err
+	}
+	return database.RemoveShareLink(link)
+}
+
+// clearShareLinks remove the share links that cannot be downloaded anymore
+func clearShareLinks() {
+	links, err := database.ExpiredShareLinks()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	for _, link := range links {
+		if err := removeShareLink(link); err != nil {
+			log.Println(err)
+		}
+	}
+}
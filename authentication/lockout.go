@@ -0,0 +1,195 @@
+package authentication
+
+import (
+	"fmt"
+	"opensavecloudserver/config"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	AttemptKindUser = "user"
+	AttemptKindIp   = "ip"
+	// maxLockouts bound the memory used by the failed attempts that are not locked anymore
+	maxLockouts = 10000
+)
+
+// AttemptsError is returned when a login is refused because of the previous failed attempts
+type AttemptsError struct {
+	Until time.Time
+}
+
+func (e *AttemptsError) Error() string {
+	return fmt.Sprintf("too many failed attempts, retry after %s", e.Until.Format(time.RFC3339))
+}
+
+// Lockout is the failed attempts of an account or an IP
+type Lockout struct {
+	Kind        string    `json:"kind"`
+	Value       string    `json:"value"`
+	Failures    int       `json:"failures"`
+	LastFailure time.Time `json:"last_failure"`
+	Until       time.Time `json:"until"`
+	Locked      bool      `json:"locked"`
+}
+
+var (
+	attempts   = make(map[string]*Lockout)
+	attemptsMu sync.Mutex
+)
+
+// checkAttempts refuse the login while the account or the IP must wait after its failed attempts.
+// The username is used as it is given, so an unknown user is delayed like an existing one
+func checkAttempts(username, ip string) error {
+	return checkAttemptKeys(attemptKey(AttemptKindUser, username), attemptKey(AttemptKindIp, ip))
+}
+
+// checkIpAttempts refuse the login while the IP must wait after its failed attempts, before the account is known
+func checkIpAttempts(ip string) error {
+	return checkAttemptKeys(attemptKey(AttemptKindIp, ip))
+}
+
+func checkAttemptKeys(keys ...string) error {
+	attemptsMu.Lock()
+	defer attemptsMu.Unlock()
+	now := time.Now()
+	var until time.Time
+	for _, key := range keys {
+		if lockout, ok := attempts[key]; ok && lockout.Until.After(until) {
+			until = lockout.Until
+		}
+	}
+	if until.After(now) {
+		return &AttemptsError{Until: until}
+	}
+	return nil
+}
+
+// failedAttempt record a failed login for the account and the IP
+func failedAttempt(username, ip string) {
+	lockoutConfig := config.Authentication().Lockout
+	attemptsMu.Lock()
+	defer attemptsMu.Unlock()
+	recordFailure(AttemptKindUser, username, lockoutConfig.MaxAttempts)
+	if len(ip) > 0 {
+		recordFailure(AttemptKindIp, ip, lockoutConfig.MaxIpAttempts)
+	}
+}
+
+// failedIpAttempt record a failed attempt only for the IP, when there is no account to lock
+func failedIpAttempt(ip string) {
+	if len(ip) == 0 {
+		return
+	}
+	lockoutConfig := config.Authentication().Lockout
+	attemptsMu.Lock()
+	defer attemptsMu.Unlock()
+	recordFailure(AttemptKindIp, ip, lockoutConfig.MaxIpAttempts)
+}
+
+// successfulAttempt clear the failed attempts of the account, the attempts of the IP are kept
+// so an attacker cannot reset them with their own account
+func successfulAttempt(username string) {
+	attemptsMu.Lock()
+	defer attemptsMu.Unlock()
+	delete(attempts, attemptKey(AttemptKindUser, username))
+}
+
+func recordFailure(kind, value string, maxAttempts int) {
+	lockoutConfig := config.Authentication().Lockout
+	key := attemptKey(kind, value)
+	lockout, ok := attempts[key]
+	if !ok {
+		if len(attempts) >= maxLockouts {
+			evictLockout()
+		}
+		lockout = &Lockout{
+			Kind:  kind,
+			Value: value,
+		}
+		attempts[key] = lockout
+	}
+	now := time.Now()
+	lockout.Failures++
+	lockout.LastFailure = now
+	if lockout.Failures >= maxAttempts {
+		lockout.Until = now.Add(lockoutConfig.LockDuration)
+		return
+	}
+	delay := lockoutConfig.BaseDelay << (lockout.Failures - 1)
+	if delay <= 0 || delay > lockoutConfig.LockDuration {
+		delay = lockoutConfig.LockDuration
+	}
+	lockout.Until = now.Add(delay)
+}
+
+// evictLockout remove the oldest failed attempts that are not locked anymore, attemptsMu must be locked.
+// A locked account or IP is never removed, or an attacker could unlock it by failing with made-up usernames:
+// while all the entries are locked the map grows over maxLockouts, until clearAttempts remove the ended locks
+func evictLockout() {
+	now := time.Now()
+	var oldestKey string
+	var oldest *Lockout
+	for key, lockout := range attempts {
+		if lockout.Until.After(now) {
+			continue
+		}
+		if oldest == nil || lockout.LastFailure.Before(oldest.LastFailure) {
+			oldestKey, oldest = key, lockout
+		}
+	}
+	if oldest != nil {
+		delete(attempts, oldestKey)
+	}
+}
+
+// Lockouts list the accounts and the IPs that have failed attempts
+func Lockouts() []*Lockout {
+	attemptsMu.Lock()
+	defer attemptsMu.Unlock()
+	now := time.Now()
+	lockouts := make([]*Lockout, 0, len(attempts))
+	for _, lockout := range attempts {
+		l := *lockout
+		l.Locked = l.Until.After(now)
+		lockouts = append(lockouts, &l)
+	}
+	sort.Slice(lockouts, func(i, j int) bool {
+		return lockouts[i].LastFailure.After(lockouts[j].LastFailure)
+	})
+	return lockouts
+}
+
+// ClearLockout remove the failed attempts of an account or an IP
+func ClearLockout(kind, value string) bool {
+	attemptsMu.Lock()
+	defer attemptsMu.Unlock()
+	key := attemptKey(kind, value)
+	if _, ok := attempts[key]; !ok {
+		return false
+	}
+	delete(attempts, key)
+	return true
+}
+
+// clearAttempts forget the failed attempts that are older than the lock duration
+func clearAttempts() {
+	lockoutConfig := config.Authentication().Lockout
+	attemptsMu.Lock()
+	defer attemptsMu.Unlock()
+	now := time.Now()
+	for key, lockout := range attempts {
+		if lockout.Until.Before(now) && now.Sub(lockout.LastFailure) > lockoutConfig.LockDuration {
+			delete(attempts, key)
+		}
+	}
+}
+
+func attemptKey(kind, value string) string {
+	if kind == AttemptKindUser {
+		value = strings.ToLower(value)
+	}
+	return kind + ":" + value
+}
@@ -0,0 +1,64 @@
+package authentication
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+// withoutAttempts run the test with an empty map of failed attempts
+func withoutAttempts(t *testing.T) {
+	attemptsMu.Lock()
+	saved := attempts
+	attempts = make(map[string]*Lockout)
+	attemptsMu.Unlock()
+	t.Cleanup(func() {
+		attemptsMu.Lock()
+		attempts = saved
+		attemptsMu.Unlock()
+	})
+}
+
+// endLock make the failed attempts of the key no longer locked, like after the delay
+func endLock(key string) {
+	attemptsMu.Lock()
+	defer attemptsMu.Unlock()
+	attempts[key].Until = time.Now().Add(-time.Second)
+}
+
+func TestLockoutsAreBounded(t *testing.T) {
+	withoutAttempts(t)
+
+	for i := 0; i < maxLockouts+10; i++ {
+		ip := "10.0." + strconv.Itoa(i/256) + "." + strconv.Itoa(i%256)
+		failedIpAttempt(ip)
+		endLock(attemptKey(AttemptKindIp, ip))
+	}
+
+	attemptsMu.Lock()
+	defer attemptsMu.Unlock()
+	if len(attempts) > maxLockouts {
+		t.Fatalf("%d failed attempts are kept, the maximum is %d", len(attempts), maxLockouts)
+	}
+	if _, ok := attempts[attemptKey(AttemptKindIp, "10.0.0.0")]; ok {
+		t.Error("the oldest attempts must be removed first")
+	}
+	if _, ok := attempts[attemptKey(AttemptKindIp, "10.0.39.25")]; !ok {
+		t.Error("the last attempt must be kept")
+	}
+}
+
+func TestLockedAccountIsNotEvicted(t *testing.T) {
+	withoutAttempts(t)
+
+	for i := 0; i < 5; i++ {
+		failedAttempt("alice", "")
+	}
+	for i := 0; i < maxLockouts; i++ {
+		failedAttempt("made-up-"+strconv.Itoa(i), "")
+	}
+
+	if err := checkAttempts("alice", ""); err == nil {
+		t.Fatal("the account must stay locked when the attacker fails with made-up usernames")
+	}
+}
@@ -0,0 +1,60 @@
+package authentication
+
+import (
+	"flag"
+	"log"
+	"net/http/httptest"
+	"opensavecloudserver/config"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestMain start the mock OpenID Connect provider and load a configuration that use it and a mock directory
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "osc-authentication")
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, folder := range []string{"storage", "cache"} {
+		if err := os.Mkdir(filepath.Join(dir, folder), 0750); err != nil {
+			log.Fatal(err)
+		}
+	}
+	testProvider = newMockOidcProvider()
+	server := httptest.NewServer(testProvider)
+	testProvider.issuer = server.URL
+	configuration := `
+path:
+  storage: ` + filepath.Join(dir, "storage") + `
+  cache: ` + filepath.Join(dir, "cache") + `
+authentication:
+  oidc:
+    enabled: true
+    issuer: ` + server.URL + `
+    client_id: osc
+    redirect_url: http://localhost/api/v1/oidc/callback
+  ldap:
+    enabled: true
+    url: ldap://localhost
+    bind_dn: ` + ldapServiceDn + `
+    bind_password: ` + ldapServicePassword + `
+    base_dn: ou=people,dc=example,dc=com
+    group_filter: (member=%s)
+    admin_group: ` + ldapAdminGroup + `
+`
+	path := filepath.Join(dir, "config.yml")
+	if err := os.WriteFile(path, []byte(configuration), 0600); err != nil {
+		log.Fatal(err)
+	}
+	// The flags of the test are parsed before config.Init parse the arguments again
+	flag.Parse()
+	os.Args = []string{os.Args[0], "-config", path}
+	config.Init()
+	code := m.Run()
+	server.Close()
+	if err := os.RemoveAll(dir); err != nil {
+		log.Println(err)
+	}
+	os.Exit(code)
+}
@@ -0,0 +1,560 @@
+package authentication
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"opensavecloudserver/config"
+	"opensavecloudserver/database"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	oidcRequestLifetime = 10 * time.Minute
+	// oidcMaxWrongCodes is the number of wrong user codes after which the login is refused
+	oidcMaxWrongCodes = 5
+)
+
+var (
+	// ErrAuthorizationPending is returned while the user has not yet completed the authorization in the browser
+	ErrAuthorizationPending = errors.New("authorization_pending")
+	// ErrWrongUserCode is returned when the code typed by the user is not the code shown by the client
+	ErrWrongUserCode = errors.New("this code is not the code shown by the application")
+)
+
+// OidcAuthorization is given to the client to start a single sign-on. The client open AuthorizationUrl
+// in the browser of the user and show the user code, then poll OidcToken with the state and the poll key.
+// After the login at the provider, the user must type the user code in the browser to confirm that the login
+// was started by their client
+type OidcAuthorization struct {
+	AuthorizationUrl string    `json:"authorization_url"`
+	State            string    `json:"state"`
+	PollKey          string    `json:"poll_key"`
+	UserCode         string    `json:"user_code"`
+	Expire           time.Time `json:"expire"`
+}
+
+type oidcRequest struct {
+	state    string
+	nonce    string
+	verifier string
+	pollKey  string
+	userCode string
+	device   string
+	expire   time.Time
+	used     bool
+	// claims is the verified ID token, the login wait for the user code before it is completed
+	claims     jwt.MapClaims
+	confirmed  bool
+	wrongCodes int
+	token      *AccessToken
+	challenge  *TotpChallenge
+	err        error
+}
+
+type oidcProvider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksUri               string `json:"jwks_uri"`
+	keys                  map[string]interface{}
+	keysFetched           time.Time
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type oidcTokenResponse struct {
+	IdToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+var (
+	oidcRequests = make(map[string]*oidcRequest)
+	oidcMu       sync.Mutex
+	provider     *oidcProvider
+	providerMu   sync.Mutex
+	httpClient   = &http.Client{Timeout: 10 * time.Second}
+)
+
+// OidcAuthorize prepare a new authorization request with PKCE
+func OidcAuthorize(device string) (*OidcAuthorization, error) {
+	oidcConfig := config.Authentication().Oidc
+	p, err := oidcDiscovery()
+	if err != nil {
+		return nil, err
+	}
+	request := &oidcRequest{
+		device: device,
+		expire: time.Now().Add(oidcRequestLifetime),
+	}
+	for _, value := range []*string{&request.state, &request.nonce, &request.verifier, &request.pollKey} {
+		*value, err = randomToken()
+		if err != nil {
+			return nil, err
+		}
+	}
+	request.userCode, err = newUserCode()
+	if err != nil {
+		return nil, err
+	}
+	challenge := sha256.Sum256([]byte(request.verifier))
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", oidcConfig.ClientId)
+	query.Set("redirect_uri", oidcConfig.RedirectUrl)
+	query.Set("scope", strings.Join(oidcConfig.Scopes, " "))
+	query.Set("state", request.state)
+	query.Set("nonce", request.nonce)
+	query.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	query.Set("code_challenge_method", "S256")
+	authorizationUrl, err := url.Parse(p.AuthorizationEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	authorizationUrl.RawQuery = query.Encode()
+	oidcMu.Lock()
+	defer oidcMu.Unlock()
+	oidcRequests[request.state] = request
+	return &OidcAuthorization{
+		AuthorizationUrl: authorizationUrl.String(),
+		State:            request.state,
+		PollKey:          request.pollKey,
+		UserCode:         request.userCode,
+		Expire:           request.expire,
+	}, nil
+}
+
+// OidcCallback verify the authorization when the provider redirect the browser of the user to the server.
+// The state can only be used once. The code is exchanged for the ID token, then the login wait for OidcConfirm,
+// so a user that open an authorization URL sent by someone else does not give them a session
+func OidcCallback(state, code string, client ClientInfo) error {
+	if err := checkIpAttempts(client.Ip); err != nil {
+		return err
+	}
+	oidcMu.Lock()
+	request, ok := oidcRequests[state]
+	valid := ok && !request.used && request.expire.After(time.Now())
+	if valid {
+		request.used = true
+	}
+	oidcMu.Unlock()
+	if !valid {
+		failedIpAttempt(client.Ip)
+		return errors.New("this authorization request does not exist, is expired or was already used")
+	}
+	claims, err := oidcExchange(request, code)
+	oidcMu.Lock()
+	defer oidcMu.Unlock()
+	if err != nil {
+		failedIpAttempt(client.Ip)
+		request.err = err
+		return err
+	}
+	request.claims = claims
+	return nil
+}
+
+// OidcConfirm complete the login once the user typed in the browser the user code shown by their client.
+// The user is found or provisioned and a session is opened for the client, or a TOTP challenge is prepared
+// when the user has the two-factor authentication. The login is refused after too many wrong codes
+func OidcConfirm(state, userCode string, client ClientInfo) error {
+	if err := checkIpAttempts(client.Ip); err != nil {
+		return err
+	}
+	oidcMu.Lock()
+	request, ok := oidcRequests[state]
+	if !ok || request.claims == nil || request.confirmed || request.err != nil || request.expire.Before(time.Now()) {
+		oidcMu.Unlock()
+		failedIpAttempt(client.Ip)
+		return errors.New("this authorization request does not exist, is expired or was already confirmed")
+	}
+	if normalizeUserCode(userCode) != request.userCode {
+		err := ErrWrongUserCode
+		request.wrongCodes++
+		if request.wrongCodes >= oidcMaxWrongCodes {
+			err = errors.New("too many wrong codes, the login is refused")
+			request.err = err
+		}
+		oidcMu.Unlock()
+		failedIpAttempt(client.Ip)
+		return err
+	}
+	request.confirmed = true
+	oidcMu.Unlock()
+	token, challenge, err := oidcLogin(request, client)
+	oidcMu.Lock()
+	defer oidcMu.Unlock()
+	request.token = token
+	request.challenge = challenge
+	request.err = err
+	return err
+}
+
+// OidcToken give the token to the client once the user completed the authorization. When the user has the
+// two-factor authentication, a challenge is given instead, it must be given to ConnectTotp with the TOTP code
+func OidcToken(state, pollKey string) (*AccessToken, *TotpChallenge, error) {
+	oidcMu.Lock()
+	defer oidcMu.Unlock()
+	request, ok := oidcRequests[state]
+	if !ok || request.pollKey != pollKey || request.expire.Before(time.Now()) {
+		return nil, nil, errors.New("this authorization request does not exist or is expired")
+	}
+	if request.err != nil {
+		delete(oidcRequests, state)
+		return nil, nil, request.err
+	}
+	if request.token == nil && request.challenge == nil {
+		return nil, nil, ErrAuthorizationPending
+	}
+	delete(oidcRequests, state)
+	return request.token, request.challenge, nil
+}
+
+// clearOidcRequests remove the authorization requests that are expired
+func clearOidcRequests() {
+	oidcMu.Lock()
+	defer oidcMu.Unlock()
+	now := time.Now()
+	for state, request := range oidcRequests {
+		if request.expire.Before(now) {
+			delete(oidcRequests, state)
+		}
+	}
+}
+
+// oidcLogin open a session for the user of the confirmed request
+func oidcLogin(request *oidcRequest, client ClientInfo) (*AccessToken, *TotpChallenge, error) {
+	user, err := oidcUser(request.claims)
+	if err != nil {
+		failedIpAttempt(client.Ip)
+		return nil, nil, err
+	}
+	if err := checkAttempts(user.Username, client.Ip); err != nil {
+		return nil, nil, err
+	}
+	if user.TotpEnabled {
+		challenge, err := totpChallenge(user)
+		if err != nil {
+			return nil, nil, err
+		}
+		return nil, challenge, nil
+	}
+	successfulAttempt(user.Username)
+	client.Device = request.device
+	session, err := newSession(user.ID, client)
+	if err != nil {
+		return nil, nil, err
+	}
+	token, err := issueTokens(session)
+	if err != nil {
+		return nil, nil, err
+	}
+	return token, nil, nil
+}
+
+// oidcExchange exchange the code for the ID token with the PKCE verifier of the request, then verify the ID token
+func oidcExchange(request *oidcRequest, code string) (jwt.MapClaims, error) {
+	oidcConfig := config.Authentication().Oidc
+	p, err := oidcDiscovery()
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", oidcConfig.RedirectUrl)
+	form.Set("client_id", oidcConfig.ClientId)
+	form.Set("code_verifier", request.verifier)
+	req, err := http.NewRequest(http.MethodPost, p.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if len(oidcConfig.ClientSecret) > 0 {
+		req.SetBasicAuth(url.QueryEscape(oidcConfig.ClientId), url.QueryEscape(oidcConfig.ClientSecret))
+	}
+	tokenResponse := new(oidcTokenResponse)
+	if err := doJson(req, tokenResponse); err != nil {
+		return nil, err
+	}
+	if len(tokenResponse.Error) > 0 {
+		return nil, fmt.Errorf("the provider refused the code: %s %s", tokenResponse.Error, tokenResponse.ErrorDescription)
+	}
+	return verifyIdToken(p, tokenResponse.IdToken, request.nonce)
+}
+
+// verifyIdToken check the signature, the issuer, the audience and the nonce of the ID token
+func verifyIdToken(p *oidcProvider, idToken, nonce string) (jwt.MapClaims, error) {
+	var claims jwt.MapClaims
+	_, err := jwt.ParseWithClaims(idToken, &claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, err := providerKey(p, kid)
+		if err != nil {
+			return nil, err
+		}
+		switch key.(type) {
+		case *rsa.PublicKey:
+			switch token.Method.(type) {
+			case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+				return key, nil
+			}
+		case *ecdsa.PublicKey:
+			if _, ok := token.Method.(*jwt.SigningMethodECDSA); ok {
+				return key, nil
+			}
+		case ed25519.PublicKey:
+			if _, ok := token.Method.(*jwt.SigningMethodEd25519); ok {
+				return key, nil
+			}
+		}
+		return nil, errors.New("the ID token algorithm does not match the key")
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !claims.VerifyIssuer(p.Issuer, true) {
+		return nil, errors.New("the ID token issuer is not valid")
+	}
+	if !claims.VerifyAudience(config.Authentication().Oidc.ClientId, true) {
+		return nil, errors.New("the ID token audience is not valid")
+	}
+	if _, ok := claims["exp"]; !ok {
+		return nil, errors.New("the ID token does not have an expiration date")
+	}
+	if n, ok := claims["nonce"].(string); !ok || n != nonce {
+		return nil, errors.New("the ID token nonce is not valid")
+	}
+	if sub, ok := claims["sub"].(string); !ok || len(sub) == 0 {
+		return nil, errors.New("the ID token does not have a subject")
+	}
+	return claims, nil
+}
+
+// oidcUser find the user linked to the subject, link an existing user or provision a new one
+func oidcUser(claims jwt.MapClaims) (*database.User, error) {
+	oidcConfig := config.Authentication().Oidc
+	subject := claims["sub"].(string)
+	username, ok := claims[oidcConfig.UsernameClaim].(string)
+	if !ok || len(username) == 0 {
+		username = subject
+	}
+	user, err := database.UserByOidcSubject(subject)
+	if err != nil {
+		user, err = database.UserByUsername(username)
+		if err == nil {
+			if !oidcConfig.LinkByUsername || !verifiedEmail(claims, username) {
+				return nil, errors.New("a local user already use this username")
+			}
+		} else {
+			if !oidcConfig.AutoProvision {
+				return nil, errors.New("this user does not exist on the server")
+			}
+			password, err := randomToken()
+			if err != nil {
+				return nil, err
+			}
+			hash, err := hashPassword(password)
+			if err != nil {
+				return nil, err
+			}
+			user = &database.User{
+				Username: username,
+				Password: hash,
+				Role:     database.UserRole,
+			}
+		}
+		user.OidcSubject = &subject
+	}
+	if len(oidcConfig.AdminGroup) > 0 {
+		user.Role = adminGroupRole(user.Role, hasGroup(claims[oidcConfig.GroupsClaim], oidcConfig.AdminGroup))
+		user.IsAdmin = user.Role == database.AdminRole
+	}
+	if err := database.SaveUser(user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// verifiedEmail check that the username is the email of the ID token and that the provider verified it.
+// The other claims, like preferred_username, can often be changed by the user at the provider, so they
+// cannot be trusted to link an existing user
+func verifiedEmail(claims jwt.MapClaims, username string) bool {
+	email, _ := claims["email"].(string)
+	verified, _ := claims["email_verified"].(bool)
+	return verified && len(email) > 0 && strings.EqualFold(email, username)
+}
+
+func hasGroup(claim interface{}, group string) bool {
+	switch groups := claim.(type) {
+	case string:
+		for _, g := range strings.Fields(groups) {
+			if g == group {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, g := range groups {
+			if s, ok := g.(string); ok && s == group {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// oidcDiscovery get the endpoints of the provider, they are fetched only once
+func oidcDiscovery() (*oidcProvider, error) {
+	providerMu.Lock()
+	defer providerMu.Unlock()
+	if provider != nil {
+		return provider, nil
+	}
+	issuer := strings.TrimSuffix(config.Authentication().Oidc.Issuer, "/")
+	req, err := http.NewRequest(http.MethodGet, issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	p := new(oidcProvider)
+	if err := doJson(req, p); err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(p.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("the provider issuer '%s' does not match the configuration", p.Issuer)
+	}
+	provider = p
+	return provider, nil
+}
+
+// providerKey get a key of the provider, the keys are fetched again when a new key id is found
+func providerKey(p *oidcProvider, kid string) (interface{}, error) {
+	providerMu.Lock()
+	defer providerMu.Unlock()
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(p.keysFetched) < time.Minute {
+		return nil, errors.New("the key of the ID token is unknown")
+	}
+	req, err := http.NewRequest(http.MethodGet, p.JwksUri, nil)
+	if err != nil {
+		return nil, err
+	}
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := doJson(req, &jwks); err != nil {
+		return nil, err
+	}
+	p.keys = make(map[string]interface{})
+	p.keysFetched = time.Now()
+	for _, jwk := range jwks.Keys {
+		key, err := jwk.publicKey()
+		if err != nil {
+			continue
+		}
+		p.keys[jwk.Kid] = key
+	}
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, errors.New("the key of the ID token is unknown")
+}
+
+func (jwk jsonWebKey) publicKey() (interface{}, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve '%s'", jwk.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}, nil
+	case "OKP":
+		if jwk.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve '%s'", jwk.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type '%s'", jwk.Kty)
+}
+
+// doJson send the request to the provider and decode the JSON response
+func doJson(req *http.Request, v interface{}) error {
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func(body io.ReadCloser) {
+		err := body.Close()
+		if err != nil {
+			log.Println(err)
+		}
+	}(res.Body)
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if res.StatusCode >= 500 {
+		return fmt.Errorf("the provider responded with the status %d", res.StatusCode)
+	}
+	return json.Unmarshal(body, v)
+}
@@ -0,0 +1,268 @@
+package authentication
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/golang-jwt/jwt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const mockKeyId = "mock"
+
+// testProvider is the provider of the configuration of the tests
+var testProvider *mockOidcProvider
+
+// mockAuthorization is a code given by the mock provider, with the PKCE challenge and the nonce of its request
+type mockAuthorization struct {
+	challenge string
+	nonce     string
+	subject   string
+}
+
+// mockOidcProvider is an OpenID Connect provider with the discovery, the JWKS and the token endpoints
+type mockOidcProvider struct {
+	issuer        string
+	key           *rsa.PrivateKey
+	codes         map[string]*mockAuthorization
+	tokenRequests int
+	mu            sync.Mutex
+}
+
+func newMockOidcProvider() *mockOidcProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	return &mockOidcProvider{
+		key:   key,
+		codes: make(map[string]*mockAuthorization),
+	}
+}
+
+func (p *mockOidcProvider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var payload interface{}
+	switch r.URL.Path {
+	case "/.well-known/openid-configuration":
+		payload = map[string]string{
+			"issuer":                 p.issuer,
+			"authorization_endpoint": p.issuer + "/authorize",
+			"token_endpoint":         p.issuer + "/token",
+			"jwks_uri":               p.issuer + "/jwks",
+		}
+	case "/jwks":
+		payload = map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": mockKeyId,
+				"n":   base64.RawURLEncoding.EncodeToString(p.key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+			}},
+		}
+	case "/token":
+		payload = p.token(r)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(payload); err != nil {
+		panic(err)
+	}
+}
+
+// token exchange a code when the verifier match the challenge of the authorization request
+func (p *mockOidcProvider) token(r *http.Request) interface{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.tokenRequests++
+	if err := r.ParseForm(); err != nil {
+		return map[string]string{"error": "invalid_request"}
+	}
+	authorization, ok := p.codes[r.PostForm.Get("code")]
+	if !ok {
+		return map[string]string{"error": "invalid_grant", "error_description": "unknown code"}
+	}
+	verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(verifier[:]) != authorization.challenge {
+		return map[string]string{"error": "invalid_grant", "error_description": "PKCE verification failed"}
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":   p.issuer,
+		"aud":   r.PostForm.Get("client_id"),
+		"sub":   authorization.subject,
+		"nonce": authorization.nonce,
+		"exp":   time.Now().Add(time.Minute).Unix(),
+	})
+	token.Header["kid"] = mockKeyId
+	idToken, err := token.SignedString(p.key)
+	if err != nil {
+		return map[string]string{"error": "server_error"}
+	}
+	return map[string]string{"id_token": idToken}
+}
+
+// authorize simulate the login of the user on the provider and give the code of the authorization url.
+// When nonce is not empty, it replaces the nonce of the request in the ID token
+func (p *mockOidcProvider) authorize(t *testing.T, authorizationUrl, subject, nonce string) string {
+	u, err := url.Parse(authorizationUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := u.Query()
+	if query.Get("code_challenge_method") != "S256" {
+		t.Fatalf("the code challenge method is '%s'", query.Get("code_challenge_method"))
+	}
+	if len(nonce) == 0 {
+		nonce = query.Get("nonce")
+	}
+	code, err := randomToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.codes[code] = &mockAuthorization{
+		challenge: query.Get("code_challenge"),
+		nonce:     nonce,
+		subject:   subject,
+	}
+	return code
+}
+
+func oidcRequestByState(t *testing.T, state string) *oidcRequest {
+	oidcMu.Lock()
+	defer oidcMu.Unlock()
+	request, ok := oidcRequests[state]
+	if !ok {
+		t.Fatal("the authorization request is not registered")
+	}
+	return request
+}
+
+func TestOidcLogin(t *testing.T) {
+	authorization, err := OidcAuthorize("test device")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := testProvider
+	code := p.authorize(t, authorization.AuthorizationUrl, "user-1", "")
+	claims, err := oidcExchange(oidcRequestByState(t, authorization.State), code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims["sub"] != "user-1" {
+		t.Errorf("the subject is '%v', expected 'user-1'", claims["sub"])
+	}
+}
+
+func TestOidcBadState(t *testing.T) {
+	err := OidcCallback("unknown state", "code", ClientInfo{Ip: "192.0.2.1"})
+	if err == nil {
+		t.Fatal("an unknown state is accepted")
+	}
+	var attemptsErr *AttemptsError
+	if errors.As(err, &attemptsErr) {
+		t.Fatal("the first failed attempt is refused by the lockout")
+	}
+	if err := OidcCallback("unknown state", "code", ClientInfo{Ip: "192.0.2.1"}); !errors.As(err, &attemptsErr) {
+		t.Errorf("a failed state does not delay the IP: %v", err)
+	}
+}
+
+func TestOidcStateUsedOnce(t *testing.T) {
+	authorization, err := OidcAuthorize("test device")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := testProvider
+	p.mu.Lock()
+	before := p.tokenRequests
+	p.mu.Unlock()
+	// The code is unknown to the provider, the first callback fail after the exchange
+	if err := OidcCallback(authorization.State, "unknown code", ClientInfo{Ip: "192.0.2.2"}); err == nil {
+		t.Fatal("an unknown code is accepted")
+	}
+	err = OidcCallback(authorization.State, "unknown code", ClientInfo{Ip: "192.0.2.3"})
+	if err == nil || !strings.Contains(err.Error(), "already used") {
+		t.Errorf("a replayed state is not refused: %v", err)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.tokenRequests-before != 1 {
+		t.Errorf("the provider received %d token requests, expected 1", p.tokenRequests-before)
+	}
+}
+
+func TestOidcBadNonce(t *testing.T) {
+	authorization, err := OidcAuthorize("test device")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := testProvider
+	code := p.authorize(t, authorization.AuthorizationUrl, "user-1", "another nonce")
+	_, err = oidcExchange(oidcRequestByState(t, authorization.State), code)
+	if err == nil || !strings.Contains(err.Error(), "nonce") {
+		t.Errorf("an ID token with another nonce is accepted: %v", err)
+	}
+}
+
+func TestOidcPkceMismatch(t *testing.T) {
+	authorization, err := OidcAuthorize("test device")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := testProvider
+	code := p.authorize(t, authorization.AuthorizationUrl, "user-1", "")
+	request := *oidcRequestByState(t, authorization.State)
+	request.verifier = "another verifier"
+	_, err = oidcExchange(&request, code)
+	if err == nil || !strings.Contains(err.Error(), "PKCE") {
+		t.Errorf("a code is exchanged with another verifier: %v", err)
+	}
+}
+
+func TestOidcConfirmWithWrongCode(t *testing.T) {
+	authorization, err := OidcAuthorize("test device")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := testProvider
+	code := p.authorize(t, authorization.AuthorizationUrl, "user-1", "")
+	if err := OidcCallback(authorization.State, code, ClientInfo{Ip: "192.0.2.10"}); err != nil {
+		t.Fatal(err)
+	}
+	// the login of the provider alone does not give a token to the client that started it
+	if _, _, err := OidcToken(authorization.State, authorization.PollKey); err != ErrAuthorizationPending {
+		t.Fatalf("the login is completed before the confirmation: %v", err)
+	}
+	wrongCode := "BBBB-BBBB"
+	if wrongCode == authorization.UserCode {
+		wrongCode = "CCCC-CCCC"
+	}
+	for i := 0; i < oidcMaxWrongCodes; i++ {
+		err := OidcConfirm(authorization.State, wrongCode, ClientInfo{Ip: "192.0.2." + strconv.Itoa(20+i)})
+		if err == nil {
+			t.Fatal("a wrong code is accepted")
+		}
+		if i < oidcMaxWrongCodes-1 && !errors.Is(err, ErrWrongUserCode) {
+			t.Fatalf("a wrong code is refused with %v", err)
+		}
+	}
+	if _, _, err := OidcToken(authorization.State, authorization.PollKey); err == nil || err == ErrAuthorizationPending {
+		t.Errorf("the login is not refused after too many wrong codes: %v", err)
+	}
+	if err := OidcConfirm(authorization.State, authorization.UserCode, ClientInfo{Ip: "192.0.2.30"}); err == nil {
+		t.Error("the right code is accepted after too many wrong codes")
+	}
+}
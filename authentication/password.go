@@ -0,0 +1,189 @@
+package authentication
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/bcrypt"
+	"log"
+	"opensavecloudserver/config"
+	"opensavecloudserver/database"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// breachedPasswords is the set of the SHA-1 hashes of the passwords that must never be used
+var breachedPasswords map[string]struct{}
+
+// loadBreachedPasswords read the list of breached passwords. A line is a password or the SHA-1 hash
+// of a password, the format 'HASH:count' of the lists of Have I Been Pwned is also accepted
+func loadBreachedPasswords() error {
+	path := config.Features().PasswordPolicy.BreachedList
+	if len(path) == 0 {
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func(f *os.File) {
+		err := f.Close()
+		if err != nil {
+			log.Println(err)
+		}
+	}(f)
+	breached := make(map[string]struct{})
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 {
+			continue
+		}
+		if hash, ok := sha1Line(line); ok {
+			breached[hash] = struct{}{}
+			continue
+		}
+		breached[sha1Hex(line)] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	breachedPasswords = breached
+	log.Printf("%d breached passwords loaded", len(breached))
+	return nil
+}
+
+// ChangePassword set a new password to the user if it respects the password policy. The sessions and the personal
+// access tokens of the user are revoked, except the session that changed the password, use "" to revoke all of them
+func ChangePassword(userId int, password, currentSessionId string) error {
+	user, err := database.UserById(userId)
+	if err != nil {
+		return err
+	}
+	if err := checkNewPassword(user, password); err != nil {
+		return err
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	if err := database.SetPassword(user, hash, config.Features().PasswordPolicy.HistorySize); err != nil {
+		return err
+	}
+	return revokeCredentials(user, currentSessionId)
+}
+
+// revokeCredentials remove the sessions, the refresh tokens and the personal access tokens of the user after a
+// password change, the current session is kept
+func revokeCredentials(user *database.User, currentSessionId string) error {
+	if err := database.RemoveOtherUserSessions(user, currentSessionId); err != nil {
+		return err
+	}
+	return database.RemoveAllUserPersonalAccessTokens(user)
+}
+
+// checkNewPassword check the password policy and the previous passwords of the user
+func checkNewPassword(user *database.User, password string) error {
+	if err := CheckPasswordPolicy(password); err != nil {
+		return err
+	}
+	return checkPasswordReuse(user, password)
+}
+
+// CheckPasswordPolicy check the length, the character classes and the list of breached passwords
+func CheckPasswordPolicy(password string) error {
+	policy := config.Features().PasswordPolicy
+	if utf8.RuneCountInString(password) < policy.MinLength {
+		return fmt.Errorf("the password need at least %d characters", policy.MinLength)
+	}
+	var lower, upper, digit, symbol bool
+	for _, c := range password {
+		switch {
+		case unicode.IsLower(c):
+			lower = true
+		case unicode.IsUpper(c):
+			upper = true
+		case unicode.IsDigit(c):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	if policy.RequireLower && !lower {
+		return errors.New("the password need at least one lowercase letter")
+	}
+	if policy.RequireUpper && !upper {
+		return errors.New("the password need at least one uppercase letter")
+	}
+	if policy.RequireDigit && !digit {
+		return errors.New("the password need at least one digit")
+	}
+	if policy.RequireSymbol && !symbol {
+		return errors.New("the password need at least one symbol")
+	}
+	if _, ok := breachedPasswords[sha1Hex(password)]; ok {
+		return errors.New("this password is known to be breached, choose another one")
+	}
+	return nil
+}
+
+// checkPasswordReuse refuse the current password and the previous ones kept in the history
+func checkPasswordReuse(user *database.User, password string) error {
+	historySize := config.Features().PasswordPolicy.HistorySize
+	if historySize <= 0 {
+		return nil
+	}
+	if bcrypt.CompareHashAndPassword(user.Password, []byte(password)) == nil {
+		return errors.New("the new password must be different from the current one")
+	}
+	history, err := database.PasswordHistoryByUserId(user.ID, historySize)
+	if err != nil {
+		return err
+	}
+	for _, entry := range history {
+		if bcrypt.CompareHashAndPassword(entry.Hash, []byte(password)) == nil {
+			return fmt.Errorf("the password cannot be one of your %d previous passwords", historySize)
+		}
+	}
+	return nil
+}
+
+// rehashPassword update the hash of the password when the configured cost has changed
+func rehashPassword(user *database.User, password string) {
+	cost, err := bcrypt.Cost(user.Password)
+	if err != nil || cost == *config.Features().PasswordHashCost {
+		return
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if err := database.UpdatePasswordHash(user, hash); err != nil {
+		log.Println(err)
+	}
+}
+
+func hashPassword(password string) ([]byte, error) {
+	return bcrypt.GenerateFromPassword([]byte(password), *config.Features().PasswordHashCost)
+}
+
+func sha1Line(line string) (string, bool) {
+	hash := strings.SplitN(line, ":", 2)[0]
+	if len(hash) != sha1.Size*2 {
+		return "", false
+	}
+	if _, err := hex.DecodeString(hash); err != nil {
+		return "", false
+	}
+	return strings.ToLower(hash), true
+}
+
+func sha1Hex(password string) string {
+	h := sha1.Sum([]byte(password))
+	return hex.EncodeToString(h[:])
+}
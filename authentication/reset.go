@@ -0,0 +1,113 @@
+package authentication
+
+import (
+	"errors"
+	"log"
+	"opensavecloudserver/config"
+	"opensavecloudserver/database"
+	"time"
+)
+
+const (
+	AuditPasswordResetCreated = "password_reset_created"
+	AuditPasswordResetUsed    = "password_reset_used"
+	AuditPasswordResetFailed  = "password_reset_failed"
+)
+
+// CreatedPasswordReset is given once to the administrator, the token is not stored on the server
+type CreatedPasswordReset struct {
+	UserId int       `json:"user_id"`
+	Token  string    `json:"token"`
+	Expire time.Time `json:"expire"`
+}
+
+// PasswordReset is the request of a user to set a new password with a reset token
+type PasswordReset struct {
+	Token          string `json:"token"`
+	Password       string `json:"password"`
+	VerifyPassword string `json:"verify_password"`
+}
+
+// CreatePasswordReset create a single-use reset token for the user, the previous unused tokens are invalidated
+func CreatePasswordReset(adminId, userId int, ip string) (*CreatedPasswordReset, error) {
+	user, err := database.UserById(userId)
+	if err != nil {
+		return nil, err
+	}
+	token, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	reset := &database.PasswordReset{
+		UserId:    user.ID,
+		Hash:      hashToken(token),
+		Expire:    time.Now().Add(config.Authentication().PasswordResetLifetime),
+		CreatedBy: adminId,
+		CreatedAt: time.Now(),
+	}
+	if err := database.AddPasswordReset(reset); err != nil {
+		return nil, err
+	}
+	audit(AuditPasswordResetCreated, adminId, user.ID, ip, "")
+	return &CreatedPasswordReset{
+		UserId: user.ID,
+		Token:  token,
+		Expire: reset.Expire,
+	}, nil
+}
+
+// ResetPassword set the new password of the user of the token, then close all their sessions
+func ResetPassword(reset *PasswordReset, ip string) error {
+	if reset.Password != reset.VerifyPassword {
+		return errors.New("password are not the same")
+	}
+	r, err := database.PasswordResetByHash(hashToken(reset.Token))
+	if err != nil {
+		audit(AuditPasswordResetFailed, 0, 0, ip, "unknown token")
+		return errors.New("this reset token is not valid")
+	}
+	if r.UsedAt != nil || r.Expire.Before(time.Now()) {
+		audit(AuditPasswordResetFailed, 0, r.UserId, ip, "token already used or expired")
+		return errors.New("this reset token is not valid")
+	}
+	user, err := database.UserById(r.UserId)
+	if err != nil {
+		return err
+	}
+	if err := checkNewPassword(user, reset.Password); err != nil {
+		// The token is kept, so the user can retry with a password that respects the policy
+		audit(AuditPasswordResetFailed, 0, user.ID, ip, err.Error())
+		return err
+	}
+	if err := database.UsePasswordReset(r); err != nil {
+		audit(AuditPasswordResetFailed, 0, user.ID, ip, "token already used")
+		return errors.New("this reset token is not valid")
+	}
+	hash, err := hashPassword(reset.Password)
+	if err != nil {
+		return err
+	}
+	if err := database.SetPassword(user, hash, config.Features().PasswordPolicy.HistorySize); err != nil {
+		return err
+	}
+	if err := revokeCredentials(user, ""); err != nil {
+		return err
+	}
+	audit(AuditPasswordResetUsed, 0, r.UserId, ip, "")
+	return nil
+}
+
+// audit record an event, a failure is only logged so it does not block the action
+func audit(action string, actorId, userId int, ip, detail string) {
+	event := &database.AuditEvent{
+		Action:    action,
+		ActorId:   actorId,
+		UserId:    userId,
+		Ip:        ip,
+		Detail:    detail,
+		CreatedAt: time.Now(),
+	}
+	if err := database.AddAuditEvent(event); err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,91 @@
+package authentication
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"opensavecloudserver/config"
+	"opensavecloudserver/database"
+	"time"
+)
+
+// lastSeenPrecision is the minimal delay between two updates of the last seen date of a session
+const lastSeenPrecision = time.Minute
+
+// ClientInfo describe the client that open or use a session
+type ClientInfo struct {
+	Device string
+	Ip     string
+}
+
+// newSession open a session for the user, the session live as long as it is refreshed
+func newSession(userId int, client ClientInfo) (*database.Session, error) {
+	now := time.Now()
+	session := &database.Session{
+		ID:        uuid.New().String(),
+		UserId:    userId,
+		Device:    client.Device,
+		Ip:        client.Ip,
+		CreatedAt: now,
+		LastSeen:  now,
+		Expire:    now.Add(config.Authentication().RefreshTokenLifetime),
+	}
+	if err := database.CreateSession(session); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// checkSession verify that the session of the token is still open and update its last seen date
+func checkSession(info *TokenInfo) error {
+	session, err := database.SessionById(info.SessionId)
+	if err != nil {
+		return errors.New("the session of this token is closed")
+	}
+	if session.UserId != info.UserId {
+		return errors.New("the session of this token belong to another user")
+	}
+	now := time.Now()
+	if session.Expire.Before(now) {
+		return errors.New("the session of this token is expired")
+	}
+	if now.Sub(session.LastSeen) > lastSeenPrecision {
+		session.LastSeen = now
+		return database.SaveSession(session)
+	}
+	return nil
+}
+
+// Logout close the session, the tokens of the session are no longer accepted
+func Logout(sessionId string) error {
+	session, err := database.SessionById(sessionId)
+	if err != nil {
+		return err
+	}
+	return database.RemoveSession(session)
+}
+
+// Sessions list the active sessions of the user, the session currentSessionId is flagged as the current one
+func Sessions(userId int, currentSessionId string) ([]*database.Session, error) {
+	sessions, err := database.SessionsByUserId(userId)
+	if err != nil {
+		return nil, err
+	}
+	for _, session := range sessions {
+		if session.ID == currentSessionId {
+			session.Current = true
+		}
+	}
+	return sessions, nil
+}
+
+// RevokeSession close a session of the user
+func RevokeSession(userId int, sessionId string) error {
+	session, err := database.SessionById(sessionId)
+	if err != nil {
+		return err
+	}
+	if session.UserId != userId {
+		return errors.New("this session does not exist")
+	}
+	return database.RemoveSession(session)
+}
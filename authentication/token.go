@@ -0,0 +1,168 @@
+package authentication
+
+import (
+	"errors"
+	"fmt"
+	"opensavecloudserver/database"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// personalAccessTokenPrefix is the prefix of the personal access tokens, it is used to tell them apart from the JWT
+const personalAccessTokenPrefix = "osc_"
+
+const (
+	// ScopeRead allow to list the games and download the saves
+	ScopeRead = "read"
+	// ScopeUpload allow to create games and to upload their saves. 'upload:<game id>' restrict the upload to one game
+	ScopeUpload = "upload"
+	// ScopeManage allow to remove the games and the groups, and to manage the shares, the share links and the members
+	ScopeManage = "manage"
+	// ScopeAdmin allow to use the administration routes, the owner of the token must be an admin
+	ScopeAdmin = "admin"
+)
+
+type NewPersonalAccessToken struct {
+	Name   string     `json:"name"`
+	Scopes []string   `json:"scopes"`
+	Expire *time.Time `json:"expire"`
+}
+
+// CreatedPersonalAccessToken is the only time the clear token is given to the user
+type CreatedPersonalAccessToken struct {
+	*database.PersonalAccessToken
+	Token string `json:"token"`
+}
+
+// CreatePersonalAccessToken create a named token restricted to the given scopes
+func CreatePersonalAccessToken(userId int, info *NewPersonalAccessToken) (*CreatedPersonalAccessToken, error) {
+	if len(strings.TrimSpace(info.Name)) == 0 {
+		return nil, errors.New("the name of the token is missing")
+	}
+	if len(info.Scopes) == 0 {
+		return nil, errors.New("the token need at least one scope")
+	}
+	if info.Expire != nil && info.Expire.Before(time.Now()) {
+		return nil, errors.New("the expiration date is in the past")
+	}
+	user, err := database.UserById(userId)
+	if err != nil {
+		return nil, err
+	}
+	for _, scope := range info.Scopes {
+		if err := checkScope(user, scope); err != nil {
+			return nil, err
+		}
+	}
+	secret, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	token := personalAccessTokenPrefix + secret
+	stored, err := database.AddPersonalAccessToken(userId, info.Name, hashToken(token), info.Scopes, info.Expire)
+	if err != nil {
+		return nil, err
+	}
+	return &CreatedPersonalAccessToken{
+		PersonalAccessToken: stored,
+		Token:               token,
+	}, nil
+}
+
+// PersonalAccessTokens list the personal access tokens of the user
+func PersonalAccessTokens(userId int) ([]*database.PersonalAccessToken, error) {
+	return database.PersonalAccessTokensByUserId(userId)
+}
+
+// RevokePersonalAccessToken remove a personal access token of the user
+func RevokePersonalAccessToken(userId, tokenId int) error {
+	token, err := database.PersonalAccessTokenById(userId, tokenId)
+	if err != nil {
+		return err
+	}
+	return database.RemovePersonalAccessToken(token)
+}
+
+// Authenticate check a personal access token or a JWT and return the information it contains
+func Authenticate(token string) (*TokenInfo, error) {
+	if strings.HasPrefix(token, personalAccessTokenPrefix) {
+		return parsePersonalAccessToken(token)
+	}
+	return ParseTokenInfo(token)
+}
+
+// HasScope tell if the token allow the action on the game, use 0 when the action is not bound to a game.
+// Tokens of a session are not restricted
+func (info *TokenInfo) HasScope(scope string, gameId int) bool {
+	if info.Scopes == nil {
+		return true
+	}
+	for _, s := range info.Scopes {
+		if s == scope {
+			return true
+		}
+		if gameId > 0 && s == fmt.Sprintf("%s:%d", scope, gameId) {
+			return true
+		}
+	}
+	return false
+}
+
+func parsePersonalAccessToken(token string) (*TokenInfo, error) {
+	stored, err := database.PersonalAccessTokenByHash(hashToken(token))
+	if err != nil {
+		return nil, errors.New("this token does not exist")
+	}
+	now := time.Now()
+	if stored.Expire != nil && stored.Expire.Before(now) {
+		return nil, errors.New("this token is expired")
+	}
+	if stored.LastUsed == nil || now.Sub(*stored.LastUsed) > lastSeenPrecision {
+		stored.LastUsed = &now
+		if err := database.SavePersonalAccessToken(stored); err != nil {
+			return nil, err
+		}
+	}
+	info := &TokenInfo{
+		ID:       strconv.Itoa(stored.ID),
+		UserId:   stored.UserId,
+		IssuedAt: stored.CreatedAt,
+		Scopes:   stored.ScopeList,
+	}
+	if stored.Expire != nil {
+		info.Expire = *stored.Expire
+	}
+	if info.Scopes == nil {
+		info.Scopes = []string{}
+	}
+	return info, nil
+}
+
+func checkScope(user *database.User, scope string) error {
+	switch scope {
+	case ScopeRead, ScopeUpload, ScopeManage:
+		return nil
+	case ScopeAdmin:
+		role, err := database.RoleByName(user.Role)
+		if err != nil || len(role.PermissionList) == 0 {
+			return errors.New("only an administrator can create a token with the admin scope")
+		}
+		return nil
+	}
+	if strings.HasPrefix(scope, ScopeUpload+":") {
+		gameId, err := strconv.Atoi(strings.TrimPrefix(scope, ScopeUpload+":"))
+		if err != nil {
+			return fmt.Errorf("the scope '%s' is not valid", scope)
+		}
+		game, err := database.AccessibleGameById(user.ID, gameId)
+		if err != nil {
+			return fmt.Errorf("the game of the scope '%s' does not exist", scope)
+		}
+		if game.ShareLevel == database.ShareRead {
+			return fmt.Errorf("the game of the scope '%s' is shared as read-only", scope)
+		}
+		return nil
+	}
+	return fmt.Errorf("the scope '%s' does not exist", scope)
+}
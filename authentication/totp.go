@@ -0,0 +1,264 @@
+package authentication
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt"
+	"net/url"
+	"opensavecloudserver/database"
+	"strings"
+	"time"
+)
+
+const (
+	totpIssuer            = "Open Save Cloud"
+	totpPeriod            = 30
+	totpDigits            = 6
+	totpSkew              = 1
+	totpChallengeLifetime = 5 * time.Minute
+	recoveryCodeCount     = 10
+)
+
+// TotpEnrollment is the secret to add to the authenticator application
+type TotpEnrollment struct {
+	Secret string `json:"secret"`
+	Uri    string `json:"uri"`
+}
+
+// TotpChallenge is given by Connect instead of a token when the user must give a TOTP code
+type TotpChallenge struct {
+	TotpRequired bool      `json:"totp_required"`
+	Challenge    string    `json:"challenge"`
+	Expire       time.Time `json:"expire"`
+}
+
+type RecoveryCodes struct {
+	Codes []string `json:"recovery_codes"`
+}
+
+// EnrollTotp generate a new TOTP secret for the user, the two-factor authentication is enabled
+// only after the confirmation of a code with ConfirmTotp
+func EnrollTotp(userId int) (*TotpEnrollment, error) {
+	user, err := database.UserById(userId)
+	if err != nil {
+		return nil, err
+	}
+	if user.TotpEnabled {
+		return nil, errors.New("the two-factor authentication is already enabled")
+	}
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b)
+	user.TotpSecret = &secret
+	if err := database.SaveUser(user); err != nil {
+		return nil, err
+	}
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", totpIssuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(totpDigits))
+	query.Set("period", fmt.Sprint(totpPeriod))
+	uri := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + totpIssuer + ":" + user.Username,
+		RawQuery: query.Encode(),
+	}
+	return &TotpEnrollment{
+		Secret: secret,
+		Uri:    uri.String(),
+	}, nil
+}
+
+// ConfirmTotp enable the two-factor authentication if the code is valid and return the recovery codes
+func ConfirmTotp(userId int, code string) (*RecoveryCodes, error) {
+	user, err := database.UserById(userId)
+	if err != nil {
+		return nil, err
+	}
+	if user.TotpEnabled {
+		return nil, errors.New("the two-factor authentication is already enabled")
+	}
+	if user.TotpSecret == nil {
+		return nil, errors.New("the two-factor authentication is not enrolled")
+	}
+	if err := checkTotpCode(user, code); err != nil {
+		return nil, err
+	}
+	user.TotpEnabled = true
+	if err := database.SaveUser(user); err != nil {
+		return nil, err
+	}
+	return newRecoveryCodes(user)
+}
+
+// DisableTotp disable the two-factor authentication, a valid code or a recovery code is required
+func DisableTotp(userId int, code string) error {
+	user, err := database.UserById(userId)
+	if err != nil {
+		return err
+	}
+	if !user.TotpEnabled {
+		return errors.New("the two-factor authentication is not enabled")
+	}
+	if err := checkSecondFactor(user, code); err != nil {
+		return err
+	}
+	user.TotpSecret = nil
+	user.TotpEnabled = false
+	user.TotpLastStep = 0
+	if err := database.RemoveAllUserRecoveryCodes(user); err != nil {
+		return err
+	}
+	return database.SaveUser(user)
+}
+
+// RegenerateRecoveryCodes replace the recovery codes of the user, a valid code is required
+func RegenerateRecoveryCodes(userId int, code string) (*RecoveryCodes, error) {
+	user, err := database.UserById(userId)
+	if err != nil {
+		return nil, err
+	}
+	if !user.TotpEnabled {
+		return nil, errors.New("the two-factor authentication is not enabled")
+	}
+	if err := checkTotpCode(user, code); err != nil {
+		return nil, err
+	}
+	return newRecoveryCodes(user)
+}
+
+// ConnectTotp is the second step of the login, it exchanges the challenge and a TOTP or recovery code for a token
+func ConnectTotp(challenge, code string, client ClientInfo) (*AccessToken, error) {
+	var claims jwt.MapClaims
+	_, err := jwt.ParseWithClaims(challenge, &claims, verificationKey)
+	if err != nil {
+		return nil, err
+	}
+	if use, ok := claims["use"].(string); !ok || use != "totp" {
+		return nil, errors.New("this token is not a TOTP challenge")
+	}
+	userId, ok := claims["sub"].(float64)
+	if !ok {
+		return nil, errors.New("this token does not have a userId in it")
+	}
+	user, err := database.UserById(int(userId))
+	if err != nil {
+		return nil, err
+	}
+	if err := checkAttempts(user.Username, client.Ip); err != nil {
+		return nil, err
+	}
+	if err := checkSecondFactor(user, code); err != nil {
+		failedAttempt(user.Username, client.Ip)
+		return nil, err
+	}
+	successfulAttempt(user.Username)
+	session, err := newSession(user.ID, client)
+	if err != nil {
+		return nil, err
+	}
+	return issueTokens(session)
+}
+
+// totpChallenge create the short-lived token that must be given back with the TOTP code.
+// It does not have a session so it cannot be used as an access token
+func totpChallenge(user *database.User) (*TotpChallenge, error) {
+	key := signingKeyForToken()
+	expire := time.Now().Add(totpChallengeLifetime)
+	token := jwt.NewWithClaims(key.method, jwt.MapClaims{
+		"sub": user.ID,
+		"use": "totp",
+		"exp": expire.Unix(),
+	})
+	token.Header["kid"] = key.id
+	challenge, err := token.SignedString(key.private)
+	if err != nil {
+		return nil, err
+	}
+	return &TotpChallenge{
+		TotpRequired: true,
+		Challenge:    challenge,
+		Expire:       expire,
+	}, nil
+}
+
+// checkSecondFactor accept a TOTP code or one of the recovery codes of the user
+func checkSecondFactor(user *database.User, code string) error {
+	code = strings.TrimSpace(code)
+	if len(code) == totpDigits {
+		return checkTotpCode(user, code)
+	}
+	if err := database.UseRecoveryCode(user, hashToken(normalizeRecoveryCode(code))); err != nil {
+		return errors.New("the code is not valid")
+	}
+	return nil
+}
+
+// checkTotpCode verify the code against the secret of the user, a code can only be used once
+func checkTotpCode(user *database.User, code string) error {
+	if user.TotpSecret == nil {
+		return errors.New("the two-factor authentication is not enrolled")
+	}
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(*user.TotpSecret)
+	if err != nil {
+		return err
+	}
+	step := time.Now().Unix() / totpPeriod
+	for i := -totpSkew; i <= totpSkew; i++ {
+		s := step + int64(i)
+		if s <= user.TotpLastStep {
+			continue
+		}
+		if hmac.Equal([]byte(totpCode(secret, s)), []byte(strings.TrimSpace(code))) {
+			user.TotpLastStep = s
+			return database.SaveUser(user)
+		}
+	}
+	return errors.New("the code is not valid")
+}
+
+// totpCode compute the code of a time step (RFC 6238)
+func totpCode(secret []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+func newRecoveryCodes(user *database.User) (*RecoveryCodes, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		code := hex.EncodeToString(b)
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = hashToken(code)
+	}
+	if err := database.ReplaceRecoveryCodes(user, hashes); err != nil {
+		return nil, err
+	}
+	return &RecoveryCodes{
+		Codes: codes,
+	}, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(code, "-", ""))
+}
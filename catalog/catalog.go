@@ -0,0 +1,187 @@
+package catalog
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"opensavecloudserver/database"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Entry is a game of a catalog file. The file is a YAML or JSON list of entries
+type Entry struct {
+	Title         string            `yaml:"title" json:"title"`
+	Platforms     []string          `yaml:"platforms" json:"platforms"`
+	StoreIds      map[string]string `yaml:"store_ids" json:"store_ids"`
+	SaveLocations []SaveLocation    `yaml:"save_locations" json:"save_locations"`
+}
+
+type SaveLocation struct {
+	Os    string `yaml:"os" json:"os"`
+	Store string `yaml:"store" json:"store"`
+	Path  string `yaml:"path" json:"path"`
+}
+
+// ImportResult count the catalog games created and updated by an import, the entries that cannot be
+// imported are skipped and their error is reported
+type ImportResult struct {
+	Created int      `json:"created"`
+	Updated int      `json:"updated"`
+	Errors  []string `json:"errors"`
+}
+
+var operatingSystems = []string{"", "windows", "linux", "mac"}
+
+// Import read a catalog file and save its entries. An entry update the catalog game that have one of
+// its store identifiers, or else the catalog game with the same normalized title
+func Import(data []byte) (*ImportResult, error) {
+	var entries []*Entry
+	// YAML is a superset of JSON, so both formats are read by the same decoder
+	if err := yaml.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+	result := &ImportResult{
+		Errors: []string{},
+	}
+	for i, entry := range entries {
+		_, created, err := Save(entry)
+		if err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("entry %d (%s): %s", i+1, entry.Title, err))
+			continue
+		}
+		if created {
+			result.Created++
+		} else {
+			result.Updated++
+		}
+	}
+	return result, nil
+}
+
+// Save create or update the catalog game of the entry, it returns true when the game is created
+func Save(entry *Entry) (*database.CatalogGame, bool, error) {
+	game, created, err := entryGame(entry, findEntry(entry, NormalizeTitle(entry.Title)))
+	if err != nil {
+		return nil, false, err
+	}
+	if err := database.SaveCatalogGame(game); err != nil {
+		return nil, false, err
+	}
+	return game, created, nil
+}
+
+// entryGame apply the entry to the catalog game found for it, or to a new catalog game when there is none.
+// The game is not saved
+func entryGame(entry *Entry, game *database.CatalogGame) (*database.CatalogGame, bool, error) {
+	title := strings.TrimSpace(entry.Title)
+	normalizedTitle := NormalizeTitle(title)
+	if len(normalizedTitle) == 0 {
+		return nil, false, errors.New("the title is missing")
+	}
+	created := game == nil
+	if created {
+		game = &database.CatalogGame{
+			CreatedAt: time.Now(),
+		}
+	}
+	game.Title = title
+	game.NormalizedTitle = normalizedTitle
+	game.UpdatedAt = time.Now()
+	if entry.Platforms != nil {
+		game.PlatformList = make([]string, 0, len(entry.Platforms))
+		for _, platform := range entry.Platforms {
+			if platform = strings.ToLower(strings.TrimSpace(platform)); len(platform) > 0 {
+				game.PlatformList = append(game.PlatformList, platform)
+			}
+		}
+	}
+	if entry.StoreIds != nil {
+		game.StoreIds = make([]*database.CatalogStoreId, 0, len(entry.StoreIds))
+		for store, storeId := range entry.StoreIds {
+			store = strings.ToLower(strings.TrimSpace(store))
+			storeId = strings.TrimSpace(storeId)
+			if len(store) == 0 || len(storeId) == 0 {
+				return nil, false, errors.New("a store identifier is empty")
+			}
+			if other, err := database.CatalogGameByStoreId(store, storeId); err == nil && other.ID != game.ID {
+				return nil, false, fmt.Errorf("the %s identifier %s is already used by '%s'", store, storeId, other.Title)
+			}
+			game.StoreIds = append(game.StoreIds, &database.CatalogStoreId{
+				Store:   store,
+				StoreId: storeId,
+			})
+		}
+	}
+	if entry.SaveLocations != nil {
+		game.SaveLocations = make([]*database.CatalogSaveLocation, 0, len(entry.SaveLocations))
+		for _, location := range entry.SaveLocations {
+			os := strings.ToLower(strings.TrimSpace(location.Os))
+			if !validOs(os) {
+				return nil, false, fmt.Errorf("the OS '%s' is not supported", location.Os)
+			}
+			if len(strings.TrimSpace(location.Path)) == 0 {
+				return nil, false, errors.New("a save location is empty")
+			}
+			game.SaveLocations = append(game.SaveLocations, &database.CatalogSaveLocation{
+				Os:    os,
+				Store: strings.ToLower(strings.TrimSpace(location.Store)),
+				Path:  strings.TrimSpace(location.Path),
+			})
+		}
+	}
+	if game.PlatformList == nil {
+		game.PlatformList = []string{}
+	}
+	return game, created, nil
+}
+
+// Search find the catalog games by their title
+func Search(query string, limit int) ([]*database.CatalogGame, error) {
+	return database.SearchCatalogGames(NormalizeTitle(query), limit)
+}
+
+// Resolve find the catalog game of a free-text name, like "sims4" for "The Sims 4"
+func Resolve(name string) (*database.CatalogGame, error) {
+	normalizedTitle := NormalizeTitle(name)
+	if len(normalizedTitle) == 0 {
+		return nil, errors.New("the name is empty")
+	}
+	return database.CatalogGameByNormalizedTitle(normalizedTitle)
+}
+
+// NormalizeTitle keep only the letters and the digits of the title in lower case, without a leading "the",
+// so "The Sims 4", "Sims 4" and "sims4" have the same normalized title
+func NormalizeTitle(title string) string {
+	title = strings.ToLower(strings.TrimSpace(title))
+	title = strings.TrimPrefix(title, "the ")
+	var sb strings.Builder
+	for _, c := range title {
+		if unicode.IsLetter(c) || unicode.IsDigit(c) {
+			sb.WriteRune(c)
+		}
+	}
+	return sb.String()
+}
+
+func findEntry(entry *Entry, normalizedTitle string) *database.CatalogGame {
+	for store, storeId := range entry.StoreIds {
+		if game, err := database.CatalogGameByStoreId(strings.ToLower(strings.TrimSpace(store)), strings.TrimSpace(storeId)); err == nil {
+			return game
+		}
+	}
+	if game, err := database.CatalogGameByNormalizedTitle(normalizedTitle); err == nil {
+		return game
+	}
+	return nil
+}
+
+func validOs(os string) bool {
+	for _, o := range operatingSystems {
+		if o == os {
+			return true
+		}
+	}
+	return false
+}
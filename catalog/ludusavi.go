@@ -0,0 +1,304 @@
+package catalog
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"opensavecloudserver/database"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	OsProton = "proton"
+	// ludusaviBatchSize is the number of games of the manifest saved together
+	ludusaviBatchSize = 200
+)
+
+// ludusaviGame is a game of the Ludusavi manifest, only the save files and the store identifiers are read
+type ludusaviGame struct {
+	Files map[string]struct {
+		Tags []string `yaml:"tags"`
+		When []struct {
+			Os    string `yaml:"os"`
+			Store string `yaml:"store"`
+		} `yaml:"when"`
+	} `yaml:"files"`
+	Steam struct {
+		Id int `yaml:"id"`
+	} `yaml:"steam"`
+	Gog struct {
+		Id int `yaml:"id"`
+	} `yaml:"gog"`
+}
+
+// SavePaths is the save path rules of a catalog game for an OS. The rules of Proton are the rules of Windows,
+// they are relative to the prefix of the Steam app ID. The paths keep the placeholders of Ludusavi, like <home>
+type SavePaths struct {
+	CatalogId int                             `json:"catalog_id"`
+	Title     string                          `json:"title"`
+	Os        string                          `json:"os"`
+	SteamId   string                          `json:"steam_id,omitempty"`
+	Paths     []*database.CatalogSaveLocation `json:"paths"`
+}
+
+// ImportLudusavi read a Ludusavi manifest and save the games that have save files in the catalog.
+// The save locations of a game are replaced by the ones of the manifest, the games are saved in batches
+func ImportLudusavi(data []byte) (*ImportResult, error) {
+	var manifest map[string]*ludusaviGame
+	if err := yaml.Unmarshal(data, &manifest); err != nil {
+		return nil, err
+	}
+	titles := make([]string, 0, len(manifest))
+	for title := range manifest {
+		titles = append(titles, title)
+	}
+	sort.Strings(titles)
+	result := &ImportResult{
+		Errors: []string{},
+	}
+	batch := newLudusaviBatch()
+	for _, title := range titles {
+		entry := ludusaviEntry(title, manifest[title])
+		if len(entry.SaveLocations) == 0 {
+			continue
+		}
+		found, err := findLudusaviEntry(entry)
+		if err == nil && batch.conflicts(entry, found) {
+			// the game found can be changed by the batch, so the batch is saved before the entry is applied
+			batch.save(result)
+			found, err = findLudusaviEntry(entry)
+		}
+		if err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: %s", title, err))
+			continue
+		}
+		keepStoreIds(entry, found)
+		game, created, err := entryGame(entry, found)
+		if err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: %s", title, err))
+			continue
+		}
+		batch.add(title, game, created)
+		if len(batch.games) >= ludusaviBatchSize {
+			batch.save(result)
+		}
+	}
+	batch.save(result)
+	return result, nil
+}
+
+// ludusaviBatch is the games of the manifest that are not saved yet
+type ludusaviBatch struct {
+	titles  []string
+	games   []*database.CatalogGame
+	created []bool
+	// keys is the normalized titles, the store identifiers and the IDs of the games of the batch
+	keys map[string]bool
+}
+
+func newLudusaviBatch() *ludusaviBatch {
+	return &ludusaviBatch{
+		keys: make(map[string]bool),
+	}
+}
+
+// conflicts check if the entry or the catalog game found for it is already in the batch
+func (batch *ludusaviBatch) conflicts(entry *Entry, found *database.CatalogGame) bool {
+	if found != nil && batch.keys["id:"+strconv.Itoa(found.ID)] {
+		return true
+	}
+	if batch.keys["title:"+NormalizeTitle(entry.Title)] {
+		return true
+	}
+	for store, storeId := range entry.StoreIds {
+		if batch.keys["store:"+store+":"+storeId] {
+			return true
+		}
+	}
+	return false
+}
+
+func (batch *ludusaviBatch) add(title string, game *database.CatalogGame, created bool) {
+	batch.titles = append(batch.titles, title)
+	batch.games = append(batch.games, game)
+	batch.created = append(batch.created, created)
+	if !created {
+		batch.keys["id:"+strconv.Itoa(game.ID)] = true
+	}
+	batch.keys["title:"+game.NormalizedTitle] = true
+	for _, id := range game.StoreIds {
+		batch.keys["store:"+id.Store+":"+id.StoreId] = true
+	}
+}
+
+// save store the games of the batch and count them in the result, every game of the batch is reported
+// when the batch cannot be saved
+func (batch *ludusaviBatch) save(result *ImportResult) {
+	if len(batch.games) == 0 {
+		return
+	}
+	if err := database.SaveCatalogGames(batch.games); err != nil {
+		for _, title := range batch.titles {
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: %s", title, err))
+		}
+	} else {
+		for _, created := range batch.created {
+			if created {
+				result.Created++
+			} else {
+				result.Updated++
+			}
+		}
+	}
+	*batch = *newLudusaviBatch()
+}
+
+// PathsForOs give the save path rules of the catalog game that apply to the OS
+func PathsForOs(game *database.CatalogGame, os string) (*SavePaths, error) {
+	os = strings.ToLower(strings.TrimSpace(os))
+	if len(os) == 0 || (os != OsProton && !validOs(os)) {
+		return nil, errors.New("the OS must be 'windows', 'linux', 'mac' or 'proton'")
+	}
+	paths := &SavePaths{
+		CatalogId: game.ID,
+		Title:     game.Title,
+		Os:        os,
+		Paths:     []*database.CatalogSaveLocation{},
+	}
+	for _, id := range game.StoreIds {
+		if id.Store == "steam" {
+			paths.SteamId = id.StoreId
+		}
+	}
+	locationOs := os
+	if os == OsProton {
+		if len(paths.SteamId) == 0 {
+			return nil, errors.New("this game does not have a Steam app ID for Proton")
+		}
+		locationOs = "windows"
+	}
+	for _, location := range game.SaveLocations {
+		if location.Os != "" && location.Os != locationOs {
+			continue
+		}
+		if os == OsProton && location.Store != "" && location.Store != "steam" {
+			continue
+		}
+		paths.Paths = append(paths.Paths, location)
+	}
+	return paths, nil
+}
+
+// findLudusaviEntry find the catalog game of a game of the manifest by its Steam app ID when it has one.
+// The title is only used for the games without a Steam app ID, or to complete a catalog game that does not have one
+func findLudusaviEntry(entry *Entry) (*database.CatalogGame, error) {
+	steamId, ok := entry.StoreIds["steam"]
+	if !ok {
+		return findEntry(entry, NormalizeTitle(entry.Title)), nil
+	}
+	if game, err := database.CatalogGameByStoreId("steam", steamId); err == nil {
+		return game, nil
+	}
+	game, err := database.CatalogGameByNormalizedTitle(NormalizeTitle(entry.Title))
+	if err != nil {
+		return nil, nil
+	}
+	for _, id := range game.StoreIds {
+		if id.Store == "steam" {
+			return nil, fmt.Errorf("the title is already used by the Steam game %s", id.StoreId)
+		}
+	}
+	return game, nil
+}
+
+// keepStoreIds add to the entry the store identifiers of the catalog game that the manifest does not give,
+// so an import does not remove the identifiers of the other stores
+func keepStoreIds(entry *Entry, game *database.CatalogGame) {
+	if game == nil {
+		return
+	}
+	for _, id := range game.StoreIds {
+		if _, ok := entry.StoreIds[id.Store]; ok {
+			continue
+		}
+		if entry.StoreIds == nil {
+			entry.StoreIds = make(map[string]string)
+		}
+		entry.StoreIds[id.Store] = id.StoreId
+	}
+}
+
+// ludusaviEntry convert a game of the manifest to a catalog entry. The platforms and the store identifiers
+// are left empty when the manifest does not give them, so the ones of the catalog game are kept
+func ludusaviEntry(title string, game *ludusaviGame) *Entry {
+	entry := &Entry{
+		Title:         title,
+		SaveLocations: []SaveLocation{},
+	}
+	if game == nil {
+		return entry
+	}
+	if game.Steam.Id > 0 || game.Gog.Id > 0 {
+		entry.StoreIds = make(map[string]string)
+	}
+	if game.Steam.Id > 0 {
+		entry.StoreIds["steam"] = strconv.Itoa(game.Steam.Id)
+	}
+	if game.Gog.Id > 0 {
+		entry.StoreIds["gog"] = strconv.Itoa(game.Gog.Id)
+	}
+	platforms := make(map[string]bool)
+	paths := make([]string, 0, len(game.Files))
+	for path := range game.Files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		file := game.Files[path]
+		if !ludusaviSave(file.Tags) {
+			continue
+		}
+		if len(file.When) == 0 {
+			entry.SaveLocations = append(entry.SaveLocations, SaveLocation{Path: path})
+			continue
+		}
+		for _, when := range file.When {
+			os := strings.ToLower(when.Os)
+			if !validOs(os) {
+				continue
+			}
+			if len(os) > 0 {
+				platforms[os] = true
+			}
+			entry.SaveLocations = append(entry.SaveLocations, SaveLocation{
+				Os:    os,
+				Store: strings.ToLower(when.Store),
+				Path:  path,
+			})
+		}
+	}
+	if len(platforms) == 0 {
+		return entry
+	}
+	entry.Platforms = make([]string, 0, len(platforms))
+	for platform := range platforms {
+		entry.Platforms = append(entry.Platforms, platform)
+	}
+	sort.Strings(entry.Platforms)
+	return entry
+}
+
+// ludusaviSave check that the file is a save, a file without tags is kept because it can be a save
+func ludusaviSave(tags []string) bool {
+	if len(tags) == 0 {
+		return true
+	}
+	for _, tag := range tags {
+		if tag == "save" {
+			return true
+		}
+	}
+	return false
+}
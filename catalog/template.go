@@ -0,0 +1,127 @@
+package catalog
+
+import (
+	"errors"
+	"fmt"
+	"opensavecloudserver/database"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// TemplateVariables are the variables of the save path templates, each client replaces them with its own folders.
+// {proton_prefix} is the prefix of the game in a Steam library, <steam_library>/steamapps/compatdata/<app ID>/pfx
+var TemplateVariables = map[string]string{
+	"home":           "home folder of the user",
+	"username":       "name of the user on the OS",
+	"documents":      "documents folder of the user",
+	"app_data":       "roaming application data folder on Windows",
+	"local_app_data": "local application data folder on Windows",
+	"xdg_config":     "XDG configuration folder on Linux",
+	"xdg_data":       "XDG data folder on Linux",
+	"steam_library":  "Steam library folder that contains the game",
+	"proton_prefix":  "Proton prefix of the game, on Linux",
+	"install_dir":    "installation folder of the game",
+	"store_root":     "root folder of the store of the game",
+	"store_user_id":  "ID of the user in the store of the game",
+}
+
+var templateVariable = regexp.MustCompile(`\{([^{}]*)}`)
+
+// ludusaviPlaceholders translate the placeholders of Ludusavi to the template variables
+var ludusaviPlaceholders = strings.NewReplacer(
+	"<home>", "{home}",
+	"<osUserName>", "{username}",
+	"<winAppData>", "{app_data}",
+	"<winLocalAppData>", "{local_app_data}",
+	"<winLocalAppDataLow>", "{home}/AppData/LocalLow",
+	"<winDocuments>", "{documents}",
+	"<winPublic>", "C:/Users/Public",
+	"<winProgramData>", "C:/ProgramData",
+	"<winDir>", "C:/Windows",
+	"<xdgConfig>", "{xdg_config}",
+	"<xdgData>", "{xdg_data}",
+	"<base>", "{install_dir}",
+	"<root>", "{store_root}",
+	"<game>", "*",
+	"<storeUserId>", "{store_user_id}",
+)
+
+// protonFolders translate the Windows folders to the folders of the Proton prefix
+var protonFolders = strings.NewReplacer(
+	"{home}", "{proton_prefix}/drive_c/users/steamuser",
+	"{username}", "steamuser",
+	"{app_data}", "{proton_prefix}/drive_c/users/steamuser/AppData/Roaming",
+	"{local_app_data}", "{proton_prefix}/drive_c/users/steamuser/AppData/Local",
+	"{documents}", "{proton_prefix}/drive_c/users/steamuser/Documents",
+	"C:/", "{proton_prefix}/drive_c/",
+)
+
+// CheckTemplateOs check that the templates can be given for the OS
+func CheckTemplateOs(os string) error {
+	if len(os) == 0 || !validOs(os) {
+		return errors.New("the OS must be 'windows', 'linux' or 'mac'")
+	}
+	return nil
+}
+
+// CheckTemplate check the OS of a template and the variables of its path
+func CheckTemplate(os, path string) error {
+	if err := CheckTemplateOs(os); err != nil {
+		return err
+	}
+	if len(strings.TrimSpace(path)) == 0 {
+		return errors.New("a save path is empty")
+	}
+	if utf8.RuneCountInString(path) > 1000 {
+		return errors.New("a save path cannot be longer than 1000 characters")
+	}
+	for _, match := range templateVariable.FindAllStringSubmatch(path, -1) {
+		if _, ok := TemplateVariables[match[1]]; !ok {
+			return fmt.Errorf("the variable {%s} does not exist", match[1])
+		}
+		if match[1] == "proton_prefix" && os != "linux" {
+			return errors.New("the variable {proton_prefix} can only be used on Linux")
+		}
+	}
+	return nil
+}
+
+// CatalogTemplates give the default save path templates of a game from the rules of its catalog game. On Linux,
+// the Windows rules are added in the Proton prefix when the game has a Steam app ID
+func CatalogTemplates(game *database.CatalogGame) []*database.SavePathTemplate {
+	templates := make([]*database.SavePathTemplate, 0)
+	seen := make(map[string]bool)
+	add := func(os, path string) {
+		if seen[os+path] {
+			return
+		}
+		seen[os+path] = true
+		templates = append(templates, &database.SavePathTemplate{
+			Os:          os,
+			Path:        path,
+			FromCatalog: true,
+		})
+	}
+	for _, os := range []string{"windows", "linux", "mac"} {
+		paths, err := PathsForOs(game, os)
+		if err != nil {
+			continue
+		}
+		for _, location := range paths.Paths {
+			path := ludusaviPlaceholders.Replace(location.Path)
+			if len(paths.SteamId) > 0 {
+				path = strings.ReplaceAll(path, "<storeGameId>", paths.SteamId)
+			}
+			add(os, strings.ReplaceAll(path, "<storeGameId>", "*"))
+		}
+	}
+	if paths, err := PathsForOs(game, OsProton); err == nil {
+		for _, location := range paths.Paths {
+			path := ludusaviPlaceholders.Replace(location.Path)
+			path = strings.ReplaceAll(path, "<storeGameId>", paths.SteamId)
+			add("linux", protonFolders.Replace(path))
+		}
+	}
+	return templates
+}
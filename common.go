@@ -1,13 +1,21 @@
 package main
 
 import (
+	"flag"
 	"io"
 	"log"
+	"opensavecloudserver/authentication"
 	"opensavecloudserver/config"
 	"opensavecloudserver/database"
+	"opensavecloudserver/ludusavi"
 	"os"
 )
 
+var (
+	importLudusavi = flag.String("import-ludusavi", "", "Import a Ludusavi backup folder for the user of -import-user, then exit")
+	importUser     = flag.String("import-user", "", "Set the user that receive the games of -import-ludusavi")
+)
+
 func InitCommon() {
 	f, err := os.OpenFile("server.log", os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
 	if err != nil {
@@ -23,4 +31,21 @@ func InitCommon() {
 
 	config.Init()
 	database.Init()
+	authentication.Init()
+}
+
+// RunCommand run the command given on the command line, it returns false when the server must be started
+func RunCommand() bool {
+	if len(*importLudusavi) == 0 {
+		return false
+	}
+	if len(*importUser) == 0 {
+		log.Fatal("the flag -import-user is required to import a Ludusavi backup folder")
+	}
+	result, err := ludusavi.Import(*importUser, *importLudusavi)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("%d games and %d revisions imported, %d games skipped", result.Games, result.Revisions, len(result.Skipped))
+	return true
 }
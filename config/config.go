@@ -6,13 +6,15 @@ import (
 	"gopkg.in/yaml.v3"
 	"log"
 	"os"
+	"time"
 )
 
 type Configuration struct {
-	Server   ServerConfiguration   `yaml:"server"`
-	Database DatabaseConfiguration `yaml:"database"`
-	Features FeaturesConfiguration `yaml:"features"`
-	Path     PathConfiguration     `yaml:"path"`
+	Server         ServerConfiguration         `yaml:"server"`
+	Database       DatabaseConfiguration       `yaml:"database"`
+	Features       FeaturesConfiguration       `yaml:"features"`
+	Path           PathConfiguration           `yaml:"path"`
+	Authentication AuthenticationConfiguration `yaml:"authentication"`
 }
 
 type PathConfiguration struct {
@@ -31,9 +33,88 @@ type DatabaseConfiguration struct {
 	Password *string `yaml:"password"`
 }
 
+// AuthenticationConfiguration describe where the keys used to sign the JWT are stored.
+// Keys can be a single PEM file or a directory of PEM files, the file name without
+// extension is used as the key id. SigningKey select the key used to sign new tokens,
+// when empty the most recent private key of the directory is used
+type AuthenticationConfiguration struct {
+	Keys                  string               `yaml:"keys"`
+	SigningKey            string               `yaml:"signing_key"`
+	AccessTokenLifetime   time.Duration        `yaml:"access_token_lifetime"`
+	RefreshTokenLifetime  time.Duration        `yaml:"refresh_token_lifetime"`
+	PasswordResetLifetime time.Duration        `yaml:"password_reset_lifetime"`
+	Oidc                  OidcConfiguration    `yaml:"oidc"`
+	Ldap                  LdapConfiguration    `yaml:"ldap"`
+	Lockout               LockoutConfiguration `yaml:"lockout"`
+}
+
+// LockoutConfiguration describe the protection of the login. After a failed attempt, the next one is delayed
+// by BaseDelay, doubled at each new failure. An account or an IP is locked for LockDuration when it reach
+// its maximum number of failed attempts
+type LockoutConfiguration struct {
+	MaxAttempts   int           `yaml:"max_attempts"`
+	MaxIpAttempts int           `yaml:"max_ip_attempts"`
+	BaseDelay     time.Duration `yaml:"base_delay"`
+	LockDuration  time.Duration `yaml:"lock_duration"`
+}
+
+// LdapConfiguration describe the directory used to authenticate the users. The user is searched in BaseDn
+// with UserFilter, '%s' is replaced by the username. A user is an admin when they are a member of AdminGroup,
+// found by their 'memberOf' attribute or by a search of GroupFilter in GroupBaseDn, '%s' is replaced by the user DN
+type LdapConfiguration struct {
+	Enabled            bool   `yaml:"enabled"`
+	Url                string `yaml:"url"`
+	StartTls           bool   `yaml:"start_tls"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+	BindDn             string `yaml:"bind_dn"`
+	BindPassword       string `yaml:"bind_password"`
+	BaseDn             string `yaml:"base_dn"`
+	UserFilter         string `yaml:"user_filter"`
+	GroupBaseDn        string `yaml:"group_base_dn"`
+	GroupFilter        string `yaml:"group_filter"`
+	AdminGroup         string `yaml:"admin_group"`
+	AutoProvision      bool   `yaml:"auto_provision"`
+}
+
+// OidcConfiguration describe the OpenID Connect provider used for the single sign-on.
+// A user is found by the subject of the provider, LinkByUsername allow to link an existing user
+// with the same username and AutoProvision to create the users that does not exist yet. A user is only
+// linked when the username is an email verified by the provider (UsernameClaim set to "email"), because
+// the other claims can often be changed by the user at the provider
+type OidcConfiguration struct {
+	Enabled        bool     `yaml:"enabled"`
+	Issuer         string   `yaml:"issuer"`
+	ClientId       string   `yaml:"client_id"`
+	ClientSecret   string   `yaml:"client_secret"`
+	RedirectUrl    string   `yaml:"redirect_url"`
+	Scopes         []string `yaml:"scopes"`
+	UsernameClaim  string   `yaml:"username_claim"`
+	GroupsClaim    string   `yaml:"groups_claim"`
+	AdminGroup     string   `yaml:"admin_group"`
+	AutoProvision  bool     `yaml:"auto_provision"`
+	LinkByUsername bool     `yaml:"link_by_username"`
+}
+
+// FeaturesConfiguration enable the features of the server. With RequireInvitation, the registration is
+// open but a valid invitation code is required
 type FeaturesConfiguration struct {
-	AllowRegister    bool `yaml:"allow_register"`
-	PasswordHashCost *int `yaml:"password_hash_cost"`
+	AllowRegister     bool                        `yaml:"allow_register"`
+	RequireInvitation bool                        `yaml:"require_invitation"`
+	PasswordHashCost  *int                        `yaml:"password_hash_cost"`
+	PasswordPolicy    PasswordPolicyConfiguration `yaml:"password_policy"`
+}
+
+// PasswordPolicyConfiguration describe the rules of a new password. BreachedList is the path of a file
+// with one password or SHA-1 hash of a password per line, these passwords are refused. HistorySize is
+// the number of previous passwords that cannot be reused
+type PasswordPolicyConfiguration struct {
+	MinLength     int    `yaml:"min_length"`
+	RequireLower  bool   `yaml:"require_lower"`
+	RequireUpper  bool   `yaml:"require_upper"`
+	RequireDigit  bool   `yaml:"require_digit"`
+	RequireSymbol bool   `yaml:"require_symbol"`
+	BreachedList  string `yaml:"breached_list"`
+	HistorySize   int    `yaml:"history_size"`
 }
 
 var currentConfig *Configuration
@@ -56,15 +137,86 @@ func checkConfig() {
 	if currentConfig.Features.PasswordHashCost == nil {
 		currentConfig.Features.PasswordHashCost = new(int)
 		*currentConfig.Features.PasswordHashCost = bcrypt.DefaultCost
-	} else if *currentConfig.Features.PasswordHashCost < bcrypt.MinCost && *currentConfig.Features.PasswordHashCost > bcrypt.MaxCost {
+	} else if *currentConfig.Features.PasswordHashCost < bcrypt.MinCost || *currentConfig.Features.PasswordHashCost > bcrypt.MaxCost {
 		log.Fatalf("password_hash_cost is not on the supported range (%d < x < %d)", bcrypt.MinCost, bcrypt.MaxCost)
 	}
+	if currentConfig.Features.PasswordPolicy.MinLength <= 0 {
+		currentConfig.Features.PasswordPolicy.MinLength = 8
+	}
+	if len(currentConfig.Features.PasswordPolicy.BreachedList) > 0 {
+		if _, err := os.Stat(currentConfig.Features.PasswordPolicy.BreachedList); err != nil {
+			log.Fatal(err)
+		}
+	}
 	if _, err := os.Stat(currentConfig.Path.Storage); err != nil {
 		log.Fatal(err)
 	}
 	if _, err := os.Stat(currentConfig.Path.Cache); err != nil {
 		log.Fatal(err)
 	}
+	if currentConfig.Authentication.AccessTokenLifetime <= 0 {
+		currentConfig.Authentication.AccessTokenLifetime = 15 * time.Minute
+	}
+	if currentConfig.Authentication.RefreshTokenLifetime <= 0 {
+		currentConfig.Authentication.RefreshTokenLifetime = 30 * 24 * time.Hour
+	}
+	if currentConfig.Authentication.PasswordResetLifetime <= 0 {
+		currentConfig.Authentication.PasswordResetLifetime = 24 * time.Hour
+	}
+	checkLockoutConfig(&currentConfig.Authentication.Lockout)
+	if currentConfig.Authentication.Oidc.Enabled {
+		checkOidcConfig(&currentConfig.Authentication.Oidc)
+	}
+	if currentConfig.Authentication.Ldap.Enabled {
+		checkLdapConfig(&currentConfig.Authentication.Ldap)
+	}
+	if len(currentConfig.Authentication.Keys) > 0 {
+		if _, err := os.Stat(currentConfig.Authentication.Keys); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+func checkLockoutConfig(lockoutConfig *LockoutConfiguration) {
+	if lockoutConfig.MaxAttempts <= 0 {
+		lockoutConfig.MaxAttempts = 5
+	}
+	if lockoutConfig.MaxIpAttempts <= 0 {
+		lockoutConfig.MaxIpAttempts = 20
+	}
+	if lockoutConfig.BaseDelay <= 0 {
+		lockoutConfig.BaseDelay = time.Second
+	}
+	if lockoutConfig.LockDuration <= 0 {
+		lockoutConfig.LockDuration = 15 * time.Minute
+	}
+}
+
+func checkOidcConfig(oidcConfig *OidcConfiguration) {
+	if len(oidcConfig.Issuer) == 0 || len(oidcConfig.ClientId) == 0 || len(oidcConfig.RedirectUrl) == 0 {
+		log.Fatal("authentication.oidc need at least an issuer, a client_id and a redirect_url")
+	}
+	if len(oidcConfig.Scopes) == 0 {
+		oidcConfig.Scopes = []string{"openid", "profile"}
+	}
+	if len(oidcConfig.UsernameClaim) == 0 {
+		oidcConfig.UsernameClaim = "preferred_username"
+	}
+	if len(oidcConfig.GroupsClaim) == 0 {
+		oidcConfig.GroupsClaim = "groups"
+	}
+}
+
+func checkLdapConfig(ldapConfig *LdapConfiguration) {
+	if len(ldapConfig.Url) == 0 || len(ldapConfig.BaseDn) == 0 {
+		log.Fatal("authentication.ldap need at least an url and a base_dn")
+	}
+	if len(ldapConfig.UserFilter) == 0 {
+		ldapConfig.UserFilter = "(uid=%s)"
+	}
+	if len(ldapConfig.GroupBaseDn) == 0 {
+		ldapConfig.GroupBaseDn = ldapConfig.BaseDn
+	}
 }
 
 func Database() *DatabaseConfiguration {
@@ -75,6 +227,10 @@ func Features() *FeaturesConfiguration {
 	return &currentConfig.Features
 }
 
+func Authentication() *AuthenticationConfiguration {
+	return &currentConfig.Authentication
+}
+
 func Path() *PathConfiguration {
 	return &currentConfig.Path
 }
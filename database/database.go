@@ -1,23 +1,86 @@
 package database
 
 import (
+	"errors"
 	"fmt"
 	"github.com/google/uuid"
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 	"log"
 	"opensavecloudserver/config"
 	"os"
+	"sort"
+	"strings"
 	"time"
 )
 
 var db *gorm.DB
 
+// likeEscaper escape the wildcards of a LIKE pattern
+var likeEscaper = strings.NewReplacer("\\", "\\\\", "%", "\\%", "_", "\\_")
+
+// noUpdate is the date used to sort the games that were never updated
+const noUpdate = "1970-01-01 00:00:00"
+
+var (
+	gameSortColumns = map[string]string{
+		SortId:         "id",
+		SortName:       "name",
+		SortLastUpdate: "COALESCE(last_update, '" + noUpdate + "')",
+	}
+	userSortColumns = map[string]string{
+		SortId:   "id",
+		SortName: "username",
+	}
+)
+
+// ErrRefreshTokenUsed is returned when a refresh token is removed by another request
+var ErrRefreshTokenUsed = errors.New("the refresh token was already used")
+
+// ErrInvitationUsed is returned when the invitation is revoked or has no use left
+var ErrInvitationUsed = errors.New("the invitation has no use left")
+
 const AdminRole string = "admin"
 const UserRole string = "user"
 
+const (
+	ShareRead  string = "read"
+	ShareWrite string = "write"
+)
+
+const (
+	GroupRoleAdmin  string = "admin"
+	GroupRoleMember string = "member"
+	GroupRoleViewer string = "viewer"
+)
+
+const (
+	PermissionAll         string = "*"
+	PermissionUsersRead   string = "users:read"
+	PermissionUsersWrite  string = "users:write"
+	PermissionSessions    string = "sessions:manage"
+	PermissionSecurity    string = "security:manage"
+	PermissionInvitations string = "invitations:manage"
+	PermissionAudit       string = "audit:read"
+	PermissionRoles       string = "roles:manage"
+	PermissionCatalog     string = "catalog:manage"
+	PermissionStorage     string = "storage:manage"
+)
+
+// Permissions is the list of the permissions that can be given to a role
+var Permissions = []string{
+	PermissionUsersRead,
+	PermissionUsersWrite,
+	PermissionSessions,
+	PermissionSecurity,
+	PermissionInvitations,
+	PermissionAudit,
+	PermissionRoles,
+	PermissionCatalog,
+	PermissionStorage,
+}
+
 func Init() {
 	dbConfig := config.Database()
 	var err error
@@ -50,18 +113,90 @@ func Init() {
 	}
 }
 
-func AllUsers() ([]*User, error) {
-	var users []*User
-	err := db.Model(User{}).Find(&users).Error
+// Users get a page of the users that match the filter, with the total number of users that match the filter
+// and the cursor of the next page
+func Users(filter *UserFilter) ([]*User, int64, *Cursor, error) {
+	filtered := func() *gorm.DB {
+		query := db.Model(User{})
+		if len(filter.Search) > 0 {
+			query = query.Where("username LIKE ?", "%"+likeEscaper.Replace(filter.Search)+"%")
+		}
+		if len(filter.Role) > 0 {
+			query = query.Where(User{Role: filter.Role})
+		}
+		return query
+	}
+	var total int64
+	if err := filtered().Count(&total).Error; err != nil {
+		return nil, 0, nil, err
+	}
+	query, err := paginate(filtered(), &filter.Page, userSortColumns)
 	if err != nil {
-		return nil, err
+		return nil, 0, nil, err
+	}
+	var users []*User
+	if err := query.Find(&users).Error; err != nil {
+		return nil, 0, nil, err
+	}
+	var next *Cursor
+	if filter.Limit > 0 && len(users) > filter.Limit {
+		users = users[:filter.Limit]
+		last := users[len(users)-1]
+		next = &Cursor{Id: last.ID}
+		if filter.Sort == SortName {
+			next.Value = last.Username
+		}
 	}
 	for _, user := range users {
 		if user.Role == AdminRole {
 			user.IsAdmin = true
 		}
 	}
-	return users, nil
+	return users, total, next, nil
+}
+
+// paginate sort the query by the column of the page then by ID, start it after the cursor and limit it to one
+// more item than the page so the caller knows if there is a next page
+func paginate(query *gorm.DB, page *Page, columns map[string]string) (*gorm.DB, error) {
+	sort := page.Sort
+	if len(sort) == 0 {
+		sort = SortId
+	}
+	column, ok := columns[sort]
+	if !ok {
+		return nil, fmt.Errorf("the list cannot be sorted by '%s'", sort)
+	}
+	direction, operator := "ASC", ">"
+	if page.Desc {
+		direction, operator = "DESC", "<"
+	}
+	if page.Cursor != nil {
+		if sort == SortId {
+			query = query.Where(fmt.Sprintf("id %s ?", operator), page.Cursor.Id)
+		} else {
+			var value interface{} = page.Cursor.Value
+			if sort == SortLastUpdate {
+				value = noUpdate
+				if len(page.Cursor.Value) > 0 {
+					date, err := time.Parse(time.RFC3339Nano, page.Cursor.Value)
+					if err != nil {
+						return nil, errors.New("the cursor is not valid")
+					}
+					value = date
+				}
+			}
+			query = query.Where(fmt.Sprintf("(%[1]s %[2]s ? OR (%[1]s = ? AND id %[2]s ?))", column, operator), value, value, page.Cursor.Id)
+		}
+	}
+	if sort == SortId {
+		query = query.Order("id " + direction)
+	} else {
+		query = query.Order(fmt.Sprintf("%s %s, id %s", column, direction, direction))
+	}
+	if page.Limit > 0 {
+		query = query.Limit(page.Limit + 1)
+	}
+	return query, nil
 }
 
 // UserByUsername get a user by the username
@@ -77,6 +212,19 @@ func UserByUsername(username string) (*User, error) {
 	return user, nil
 }
 
+// UserByOidcSubject get the user linked to the subject of the OpenID Connect provider
+func UserByOidcSubject(subject string) (*User, error) {
+	var user *User
+	err := db.Model(User{}).Where(User{OidcSubject: &subject}).First(&user).Error
+	if err != nil {
+		return nil, err
+	}
+	if user.Role == AdminRole {
+		user.IsAdmin = true
+	}
+	return user, nil
+}
+
 func ChangeUsername(userId int, newUsername string) error {
 	user, err := UserById(userId)
 	if err != nil {
@@ -117,10 +265,38 @@ func RemoveUser(user *User) error {
 }
 
 func RemoveAllUserGameEntries(user *User) error {
+	err := db.Where("game_id IN (?)", db.Model(Game{}).Select("id").Where(Game{UserId: user.ID})).Delete(Revision{}).Error
+	if err != nil {
+		return err
+	}
+	err = db.Where("game_id IN (?)", db.Model(Game{}).Select("id").Where(Game{UserId: user.ID})).Delete(Screenshot{}).Error
+	if err != nil {
+		return err
+	}
+	err = db.Where("game_id IN (?)", db.Model(Game{}).Select("id").Where(Game{UserId: user.ID})).Delete(SavePathTemplate{}).Error
+	if err != nil {
+		return err
+	}
+	err = db.Where("game_id IN (?)", db.Model(Game{}).Select("id").Where(Game{UserId: user.ID})).Delete(ShareLink{}).Error
+	if err != nil {
+		return err
+	}
 	return db.Delete(Game{}, Game{UserId: user.ID}).Error
 }
 
 func RemoveGame(game *Game) error {
+	if err := db.Delete(Revision{}, Revision{GameId: game.ID}).Error; err != nil {
+		return err
+	}
+	if err := db.Delete(Screenshot{}, Screenshot{GameId: game.ID}).Error; err != nil {
+		return err
+	}
+	if err := db.Delete(SavePathTemplate{}, SavePathTemplate{GameId: game.ID}).Error; err != nil {
+		return err
+	}
+	if err := db.Delete(ShareLink{}, ShareLink{GameId: game.ID}).Error; err != nil {
+		return err
+	}
 	return db.Delete(Game{}, Game{UserId: game.UserId, ID: game.ID}).Error
 }
 
@@ -144,6 +320,238 @@ func GameInfoById(userId, gameId int) (*Game, error) {
 	return game, nil
 }
 
+// AccessibleGameById return a game of the user, a game shared with the user or a game of one of their groups.
+// The owner or the group, and the level of the share are set when the game is not owned by the user
+func AccessibleGameById(userId, gameId int) (*Game, error) {
+	game, err := GameInfoById(userId, gameId)
+	if err == nil {
+		return game, nil
+	}
+	var share *GameShare
+	err = db.Model(GameShare{}).Where(GameShare{GameId: gameId, UserId: userId}).First(&share).Error
+	if err == nil {
+		return sharedGame(share)
+	}
+	return groupGame(userId, gameId)
+}
+
+func sharedGame(share *GameShare) (*Game, error) {
+	game, err := GameInfoById(share.OwnerId, share.GameId)
+	if err != nil {
+		return nil, err
+	}
+	owner, err := UserById(share.OwnerId)
+	if err != nil {
+		return nil, err
+	}
+	game.Owner = owner.Username
+	game.ShareLevel = share.Level
+	return game, nil
+}
+
+// AccessibleGames get a page of the games of the user, of the games shared with them and of the games of their
+// groups, with the total number of games that match the filter and the cursor of the next page
+func AccessibleGames(userId int, filter *GameFilter) ([]*Game, int64, *Cursor, error) {
+	filtered := func() *gorm.DB {
+		query := db.Model(Game{}).Where(db.Where("user_id = ?", userId).
+			Or("id IN (?)", db.Model(GameShare{}).Select("game_id").Where(GameShare{UserId: userId})).
+			Or("group_id IN (?)", db.Model(GroupMember{}).Select("group_id").Where(GroupMember{UserId: userId})))
+		if len(filter.Search) > 0 {
+			query = query.Where("name LIKE ?", "%"+likeEscaper.Replace(filter.Search)+"%")
+		}
+		if filter.Available != nil {
+			query = query.Where("available = ?", *filter.Available)
+		}
+		if filter.UpdatedAfter != nil {
+			query = query.Where("last_update >= ?", *filter.UpdatedAfter)
+		}
+		if filter.UpdatedBefore != nil {
+			query = query.Where("last_update < ?", *filter.UpdatedBefore)
+		}
+		return query
+	}
+	var total int64
+	if err := filtered().Count(&total).Error; err != nil {
+		return nil, 0, nil, err
+	}
+	query, err := paginate(filtered(), &filter.Page, gameSortColumns)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	var games []*Game
+	if err := query.Find(&games).Error; err != nil {
+		return nil, 0, nil, err
+	}
+	var next *Cursor
+	if filter.Limit > 0 && len(games) > filter.Limit {
+		games = games[:filter.Limit]
+		last := games[len(games)-1]
+		next = &Cursor{Id: last.ID}
+		switch filter.Sort {
+		case SortName:
+			next.Value = last.Name
+		case SortLastUpdate:
+			if last.LastUpdate != nil {
+				next.Value = last.LastUpdate.Format(time.RFC3339Nano)
+			}
+		}
+	}
+	if err := setAccess(userId, games); err != nil {
+		return nil, 0, nil, err
+	}
+	return games, total, next, nil
+}
+
+// setAccess set the owner or the group, and the level of the share of the games not owned by the user
+func setAccess(userId int, games []*Game) error {
+	var shares []*GameShare
+	if err := db.Model(GameShare{}).Where(GameShare{UserId: userId}).Find(&shares).Error; err != nil {
+		return err
+	}
+	var members []*GroupMember
+	if err := db.Model(GroupMember{}).Where(GroupMember{UserId: userId}).Find(&members).Error; err != nil {
+		return err
+	}
+	sharesByGame := make(map[int]*GameShare)
+	for _, share := range shares {
+		sharesByGame[share.GameId] = share
+	}
+	membersByGroup := make(map[int]*GroupMember)
+	for _, member := range members {
+		membersByGroup[member.GroupId] = member
+	}
+	owners := make(map[int]string)
+	groups := make(map[int]string)
+	for _, game := range games {
+		if game.GroupId != nil {
+			member, ok := membersByGroup[*game.GroupId]
+			if !ok {
+				continue
+			}
+			if _, ok := groups[member.GroupId]; !ok {
+				group, err := GroupById(member.GroupId)
+				if err != nil {
+					return err
+				}
+				groups[member.GroupId] = group.Name
+			}
+			game.Group = groups[member.GroupId]
+			game.ShareLevel = ShareWrite
+			if member.Role == GroupRoleViewer {
+				game.ShareLevel = ShareRead
+			}
+		} else if share, ok := sharesByGame[game.ID]; ok && game.UserId != userId {
+			if _, ok := owners[share.OwnerId]; !ok {
+				owner, err := UserById(share.OwnerId)
+				if err != nil {
+					return err
+				}
+				owners[share.OwnerId] = owner.Username
+			}
+			game.Owner = owners[share.OwnerId]
+			game.ShareLevel = share.Level
+		}
+	}
+	return nil
+}
+
+// CanManageGame check that the user owns the game, or is an admin of the group of the game
+func CanManageGame(userId int, game *Game) bool {
+	if game.GroupId == nil {
+		return game.UserId == userId
+	}
+	member, err := GroupMemberById(*game.GroupId, userId)
+	return err == nil && member.Role == GroupRoleAdmin
+}
+
+// groupGame return a game of a group of the user, a viewer of the group can only read it
+func groupGame(userId, gameId int) (*Game, error) {
+	var game *Game
+	err := db.Model(Game{}).Where("id = ? AND group_id IS NOT NULL", gameId).First(&game).Error
+	if err != nil {
+		return nil, err
+	}
+	member, err := GroupMemberById(*game.GroupId, userId)
+	if err != nil {
+		return nil, err
+	}
+	group, err := GroupById(*game.GroupId)
+	if err != nil {
+		return nil, err
+	}
+	game.Group = group.Name
+	game.ShareLevel = ShareWrite
+	if member.Role == GroupRoleViewer {
+		game.ShareLevel = ShareRead
+	}
+	return game, nil
+}
+
+// GameInfosByGroupId get all the games owned by a group
+func GameInfosByGroupId(groupId int) ([]*Game, error) {
+	var games []*Game
+	err := db.Model(Game{}).Where(Game{GroupId: &groupId}).Find(&games).Error
+	if err != nil {
+		return nil, err
+	}
+	return games, nil
+}
+
+// SaveGameShare share the game with a user, the level is updated if the game is already shared with them
+func SaveGameShare(game *Game, user *User, level string) (*GameShare, error) {
+	var share *GameShare
+	err := db.Model(GameShare{}).Where(GameShare{GameId: game.ID, UserId: user.ID}).First(&share).Error
+	if err != nil {
+		share = &GameShare{
+			GameId:    game.ID,
+			OwnerId:   game.UserId,
+			UserId:    user.ID,
+			CreatedAt: time.Now(),
+		}
+	}
+	share.Level = level
+	if err := db.Save(share).Error; err != nil {
+		return nil, err
+	}
+	share.Username = user.Username
+	return share, nil
+}
+
+// GameSharesByGameId get the users that have access to the game
+func GameSharesByGameId(gameId int) ([]*GameShare, error) {
+	var shares []*GameShare
+	err := db.Model(GameShare{}).Where(GameShare{GameId: gameId}).Find(&shares).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, share := range shares {
+		if user, err := UserById(share.UserId); err == nil {
+			share.Username = user.Username
+		}
+	}
+	return shares, nil
+}
+
+func RemoveGameShare(gameId, userId int) error {
+	result := db.Delete(GameShare{}, GameShare{GameId: gameId, UserId: userId})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrRefreshTokenUsed
+	}
+	return nil
+}
+
+func RemoveAllGameShares(game *Game) error {
+	return db.Delete(GameShare{}, GameShare{GameId: game.ID}).Error
+}
+
+// RemoveAllUserGameShares remove the shares of the games of the user and the shares given to the user
+func RemoveAllUserGameShares(user *User) error {
+	return db.Where("owner_id = ? OR user_id = ?", user.ID, user.ID).Delete(GameShare{}).Error
+}
+
 // GameInfosByUserId get all saved games for a user
 func GameInfosByUserId(userId int) ([]*Game, error) {
 	var games []*Game
@@ -170,7 +578,43 @@ func CreateGame(userId int, name string) (*Game, error) {
 	return game, nil
 }
 
-func UpdateGameRevision(game *Game, hash string) error {
+// CreateGroupGame create an entry for a new game save owned by a group
+func CreateGroupGame(groupId int, name string) (*Game, error) {
+	gameUUID := uuid.New()
+	game := &Game{
+		Name:        name,
+		Revision:    0,
+		PathStorage: gameUUID.String() + ".bin",
+		GroupId:     &groupId,
+		Available:   false,
+	}
+	if err := db.Save(&game).Error; err != nil {
+		return nil, err
+	}
+	return game, nil
+}
+
+// SetGameCatalog link the game to a catalog game, nil remove the link
+func SetGameCatalog(game *Game, catalogId *int) error {
+	game.CatalogId = catalogId
+	return db.Model(game).Update("catalog_id", catalogId).Error
+}
+
+// UpdateGame save the name, the catalog game and the notes of the game
+func UpdateGame(game *Game) error {
+	return db.Model(game).Select("name", "catalog_id", "notes").Updates(game).Error
+}
+
+// ClearGameSave mark the game as without save, after its archive was moved to another game
+func ClearGameSave(game *Game) error {
+	game.Available = false
+	game.Hash = nil
+	return db.Model(game).Select("available", "hash").Updates(game).Error
+}
+
+// UpdateGameRevision set the new hash of the game and increment its revision, the information of the
+// revision is saved with it
+func UpdateGameRevision(game *Game, hash string, revision *Revision) error {
 	game.Revision += 1
 	if game.Hash == nil {
 		game.Hash = new(string)
@@ -185,23 +629,987 @@ func UpdateGameRevision(game *Game, hash string) error {
 	if err != nil {
 		return err
 	}
-	return nil
+	revision.GameId = game.ID
+	revision.Revision = game.Revision
+	revision.Hash = hash
+	revision.CreatedAt = *game.LastUpdate
+	return SaveRevision(revision)
 }
 
-// ChangePassword change the password of the user, the param 'password' must be the clear password
-func ChangePassword(userId int, password []byte) error {
-	user, err := UserById(userId)
+// MergeGameHistory give the revisions and the save path templates of a game to another game. The revisions
+// are numbered after the revision of the destination in their order, so the last one become the current
+// revision, and the numbers given to them are returned. The templates are kept for the OS the destination
+// does not have templates for. When the source has a save, it becomes the save of the destination
+func MergeGameHistory(from, to *Game, revisions []*Revision) (map[int]int, error) {
+	numbers := make(map[int]int)
+	err := db.Transaction(func(tx *gorm.DB) error {
+		sort.Slice(revisions, func(i, j int) bool {
+			return revisions[i].Revision < revisions[j].Revision
+		})
+		for _, revision := range revisions {
+			to.Revision++
+			numbers[revision.Revision] = to.Revision
+			revision.GameId = to.ID
+			revision.Revision = to.Revision
+			if revision.LabelList == nil {
+				revision.LabelList = []string{}
+			}
+			revision.Labels = strings.Join(revision.LabelList, ",")
+			if err := tx.Save(revision).Error; err != nil {
+				return err
+			}
+		}
+		var oses []string
+		err := tx.Model(SavePathTemplate{}).Where(SavePathTemplate{GameId: to.ID}).Distinct().Pluck("os", &oses).Error
+		if err != nil {
+			return err
+		}
+		templates := tx.Model(SavePathTemplate{}).Where(SavePathTemplate{GameId: from.ID})
+		if len(oses) > 0 {
+			templates = templates.Where("os NOT IN ?", oses)
+		}
+		if err := templates.Update("game_id", to.ID).Error; err != nil {
+			return err
+		}
+		if from.Available && from.Hash != nil {
+			to.Hash = new(string)
+			*to.Hash = *from.Hash
+			to.Available = true
+			to.LastUpdate = new(time.Time)
+			*to.LastUpdate = time.Now()
+		}
+		return tx.Save(to).Error
+	})
 	if err != nil {
+		return nil, err
+	}
+	return numbers, nil
+}
+
+// SetPassword change the password hash of the user, the previous hash is kept in the history
+func SetPassword(user *User, hash []byte, historySize int) error {
+	previous := user.Password
+	user.Password = hash
+	if err := db.Save(user).Error; err != nil {
 		return err
 	}
-	hashedPassword, err := bcrypt.GenerateFromPassword(password, *config.Features().PasswordHashCost)
-	if err != nil {
+	if historySize <= 0 || len(previous) == 0 {
+		return nil
+	}
+	entry := &PasswordHistory{
+		UserId:    user.ID,
+		Hash:      previous,
+		CreatedAt: time.Now(),
+	}
+	if err := db.Save(entry).Error; err != nil {
 		return err
 	}
-	user.Password = hashedPassword
-	err = db.Save(user).Error
+	history, err := PasswordHistoryByUserId(user.ID, -1)
 	if err != nil {
 		return err
 	}
+	for i := historySize; i < len(history); i++ {
+		if err := db.Delete(PasswordHistory{}, history[i].ID).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UpdatePasswordHash replace the hash of the password without changing the password, nothing is added to the history
+func UpdatePasswordHash(user *User, hash []byte) error {
+	user.Password = hash
+	return db.Save(user).Error
+}
+
+// PasswordHistoryByUserId get the previous password hashes of the user, the most recent first
+func PasswordHistoryByUserId(userId, limit int) ([]*PasswordHistory, error) {
+	var history []*PasswordHistory
+	err := db.Model(PasswordHistory{}).Where(PasswordHistory{UserId: userId}).Order("created_at desc, id desc").Limit(limit).Find(&history).Error
+	if err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+func RemoveAllUserPasswordHistory(user *User) error {
+	return db.Delete(PasswordHistory{}, PasswordHistory{UserId: user.ID}).Error
+}
+
+// AddRefreshToken save a refresh token, only the hash of the token is stored
+func AddRefreshToken(session *Session, hash string, expire time.Time) (*RefreshToken, error) {
+	refreshToken := &RefreshToken{
+		UserId:    session.UserId,
+		SessionId: session.ID,
+		Hash:      hash,
+		Expire:    expire,
+		CreatedAt: time.Now(),
+	}
+	if err := db.Save(refreshToken).Error; err != nil {
+		return nil, err
+	}
+	return refreshToken, nil
+}
+
+// RefreshTokenByHash get a refresh token by the hash of the token
+func RefreshTokenByHash(hash string) (*RefreshToken, error) {
+	var refreshToken *RefreshToken
+	err := db.Model(RefreshToken{}).Where(RefreshToken{Hash: hash}).First(&refreshToken).Error
+	if err != nil {
+		return nil, err
+	}
+	return refreshToken, nil
+}
+
+// RemoveRefreshToken remove a refresh token, ErrRefreshTokenUsed is returned when it was already removed,
+// so a token used twice at the same time is only accepted once
+func RemoveRefreshToken(refreshToken *RefreshToken) error {
+	result := db.Delete(RefreshToken{}, refreshToken.ID)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
 	return nil
 }
+
+func RemoveAllUserRefreshTokens(user *User) error {
+	return db.Delete(RefreshToken{}, RefreshToken{UserId: user.ID}).Error
+}
+
+// RemoveExpiredRefreshTokens remove the refresh tokens that can no longer be used
+func RemoveExpiredRefreshTokens() error {
+	return db.Where("expire < ?", time.Now()).Delete(RefreshToken{}).Error
+}
+
+// CreateSession save a new session
+func CreateSession(session *Session) error {
+	return db.Create(session).Error
+}
+
+// SessionById get a session by its id
+func SessionById(sessionId string) (*Session, error) {
+	var session *Session
+	err := db.Model(Session{}).Where(Session{ID: sessionId}).First(&session).Error
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// SessionsByUserId get all the active sessions of a user
+func SessionsByUserId(userId int) ([]*Session, error) {
+	var sessions []*Session
+	err := db.Model(Session{}).Where(Session{UserId: userId}).Where("expire > ?", time.Now()).Order("last_seen desc").Find(&sessions).Error
+	if err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+func SaveSession(session *Session) error {
+	return db.Save(session).Error
+}
+
+// RemoveSession remove the session and the refresh tokens bound to it
+func RemoveSession(session *Session) error {
+	if err := db.Delete(RefreshToken{}, RefreshToken{SessionId: session.ID}).Error; err != nil {
+		return err
+	}
+	return db.Delete(Session{}, Session{ID: session.ID}).Error
+}
+
+func RemoveAllUserSessions(user *User) error {
+	return db.Delete(Session{}, Session{UserId: user.ID}).Error
+}
+
+// RemoveOtherUserSessions remove the sessions of the user and their refresh tokens, except the given session
+func RemoveOtherUserSessions(user *User, sessionId string) error {
+	if err := db.Where("user_id = ? AND session_id <> ?", user.ID, sessionId).Delete(RefreshToken{}).Error; err != nil {
+		return err
+	}
+	return db.Where("user_id = ? AND id <> ?", user.ID, sessionId).Delete(Session{}).Error
+}
+
+// RemoveExpiredSessions remove the sessions that can no longer be refreshed
+func RemoveExpiredSessions() error {
+	return db.Where("expire < ?", time.Now()).Delete(Session{}).Error
+}
+
+// AddPersonalAccessToken save a personal access token, only the hash of the token is stored
+func AddPersonalAccessToken(userId int, name, hash string, scopes []string, expire *time.Time) (*PersonalAccessToken, error) {
+	token := &PersonalAccessToken{
+		UserId:    userId,
+		Name:      name,
+		Hash:      hash,
+		Scopes:    strings.Join(scopes, " "),
+		ScopeList: scopes,
+		Expire:    expire,
+		CreatedAt: time.Now(),
+	}
+	if err := db.Save(token).Error; err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// PersonalAccessTokenByHash get a personal access token by the hash of the token
+func PersonalAccessTokenByHash(hash string) (*PersonalAccessToken, error) {
+	var token *PersonalAccessToken
+	err := db.Model(PersonalAccessToken{}).Where(PersonalAccessToken{Hash: hash}).First(&token).Error
+	if err != nil {
+		return nil, err
+	}
+	token.ScopeList = strings.Fields(token.Scopes)
+	return token, nil
+}
+
+// PersonalAccessTokenById get a personal access token of a user
+func PersonalAccessTokenById(userId, tokenId int) (*PersonalAccessToken, error) {
+	var token *PersonalAccessToken
+	err := db.Model(PersonalAccessToken{}).Where(PersonalAccessToken{ID: tokenId, UserId: userId}).First(&token).Error
+	if err != nil {
+		return nil, err
+	}
+	token.ScopeList = strings.Fields(token.Scopes)
+	return token, nil
+}
+
+// PersonalAccessTokensByUserId get all the personal access tokens of a user
+func PersonalAccessTokensByUserId(userId int) ([]*PersonalAccessToken, error) {
+	var tokens []*PersonalAccessToken
+	err := db.Model(PersonalAccessToken{}).Where(PersonalAccessToken{UserId: userId}).Find(&tokens).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, token := range tokens {
+		token.ScopeList = strings.Fields(token.Scopes)
+	}
+	return tokens, nil
+}
+
+func SavePersonalAccessToken(token *PersonalAccessToken) error {
+	return db.Save(token).Error
+}
+
+func RemovePersonalAccessToken(token *PersonalAccessToken) error {
+	return db.Delete(PersonalAccessToken{}, token.ID).Error
+}
+
+func RemoveAllUserPersonalAccessTokens(user *User) error {
+	return db.Delete(PersonalAccessToken{}, PersonalAccessToken{UserId: user.ID}).Error
+}
+
+// ReplaceRecoveryCodes remove the recovery codes of the user and save the new ones, only the hashes are stored
+func ReplaceRecoveryCodes(user *User, hashes []string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(RecoveryCode{}, RecoveryCode{UserId: user.ID}).Error; err != nil {
+			return err
+		}
+		for _, hash := range hashes {
+			code := &RecoveryCode{
+				UserId: user.ID,
+				Hash:   hash,
+			}
+			if err := tx.Save(code).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// UseRecoveryCode remove the recovery code of the user, an error is returned if the code does not exist
+func UseRecoveryCode(user *User, hash string) error {
+	result := db.Delete(RecoveryCode{}, RecoveryCode{UserId: user.ID, Hash: hash})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func RemoveAllUserRecoveryCodes(user *User) error {
+	return db.Delete(RecoveryCode{}, RecoveryCode{UserId: user.ID}).Error
+}
+
+func AddInvitation(invitation *Invitation) error {
+	return db.Save(invitation).Error
+}
+
+// InvitationByHash get an invitation by the hash of its code
+func InvitationByHash(hash string) (*Invitation, error) {
+	var invitation *Invitation
+	err := db.Model(Invitation{}).Where(Invitation{Hash: hash}).First(&invitation).Error
+	if err != nil {
+		return nil, err
+	}
+	return invitation, nil
+}
+
+func InvitationById(invitationId int) (*Invitation, error) {
+	var invitation *Invitation
+	err := db.Model(Invitation{}).Where(invitationId).First(&invitation).Error
+	if err != nil {
+		return nil, err
+	}
+	return invitation, nil
+}
+
+func AllInvitations() ([]*Invitation, error) {
+	var invitations []*Invitation
+	err := db.Model(Invitation{}).Order("created_at desc").Find(&invitations).Error
+	if err != nil {
+		return nil, err
+	}
+	return invitations, nil
+}
+
+func SaveInvitation(invitation *Invitation) error {
+	return db.Save(invitation).Error
+}
+
+// AddInvitedUser create the user and count a use of the invitation in a transaction, so the invitation is not
+// used when the user cannot be created. ErrInvitationUsed is returned if the invitation has no use left
+func AddInvitedUser(user *User, invitation *Invitation) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(Invitation{}).
+			Where("id = ? AND revoked = ? AND (max_uses = 0 OR uses < max_uses)", invitation.ID, false).
+			Update("uses", gorm.Expr("uses + 1"))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrInvitationUsed
+		}
+		if err := tx.Save(user).Error; err != nil {
+			return err
+		}
+		invitation.Uses++
+		return nil
+	})
+}
+
+// AddPasswordReset save a password reset, the previous resets of the user that are not used are removed
+func AddPasswordReset(reset *PasswordReset) error {
+	err := db.Where("user_id = ? AND used_at IS NULL", reset.UserId).Delete(PasswordReset{}).Error
+	if err != nil {
+		return err
+	}
+	return db.Save(reset).Error
+}
+
+// PasswordResetByHash get a password reset by the hash of its token
+func PasswordResetByHash(hash string) (*PasswordReset, error) {
+	var reset *PasswordReset
+	err := db.Model(PasswordReset{}).Where(PasswordReset{Hash: hash}).First(&reset).Error
+	if err != nil {
+		return nil, err
+	}
+	return reset, nil
+}
+
+// UsePasswordReset mark the password reset as used, an error is returned if it was already used
+func UsePasswordReset(reset *PasswordReset) error {
+	now := time.Now()
+	result := db.Model(PasswordReset{}).Where("id = ? AND used_at IS NULL", reset.ID).Update("used_at", now)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	reset.UsedAt = &now
+	return nil
+}
+
+func RemoveAllUserPasswordResets(user *User) error {
+	return db.Delete(PasswordReset{}, PasswordReset{UserId: user.ID}).Error
+}
+
+func AddAuditEvent(event *AuditEvent) error {
+	return db.Save(event).Error
+}
+
+// AuditEvents get the most recent audit events
+func AuditEvents(limit int) ([]*AuditEvent, error) {
+	var events []*AuditEvent
+	err := db.Model(AuditEvent{}).Order("created_at desc, id desc").Limit(limit).Find(&events).Error
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// HasPermission check if the role give the permission
+func (role *Role) HasPermission(permission string) bool {
+	for _, p := range role.PermissionList {
+		if p == PermissionAll || p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// Includes check if the role give all the permissions of the other role
+func (role *Role) Includes(other *Role) bool {
+	for _, p := range other.PermissionList {
+		if !role.HasPermission(p) {
+			return false
+		}
+	}
+	return true
+}
+
+func AllRoles() ([]*Role, error) {
+	var roles []*Role
+	err := db.Model(Role{}).Order("name").Find(&roles).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, role := range roles {
+		role.PermissionList = strings.Fields(role.Permissions)
+	}
+	return roles, nil
+}
+
+// RoleByName get a role. The admin role always give all the permissions, even if it is missing from the database
+func RoleByName(name string) (*Role, error) {
+	var role *Role
+	err := db.Model(Role{}).Where(Role{Name: name}).First(&role).Error
+	if err != nil {
+		if name == AdminRole {
+			return &Role{Name: AdminRole, PermissionList: []string{PermissionAll}, BuiltIn: true}, nil
+		}
+		return nil, err
+	}
+	role.PermissionList = strings.Fields(role.Permissions)
+	if role.Name == AdminRole {
+		role.PermissionList = []string{PermissionAll}
+	}
+	return role, nil
+}
+
+func SaveRole(role *Role) error {
+	role.Permissions = strings.Join(role.PermissionList, " ")
+	return db.Save(role).Error
+}
+
+func RemoveRole(role *Role) error {
+	return db.Delete(Role{}, role.ID).Error
+}
+
+// CountUsersWithRole give the number of users that have the role
+func CountUsersWithRole(name string) (int64, error) {
+	var count int64
+	err := db.Model(User{}).Where(User{Role: name}).Count(&count).Error
+	return count, err
+}
+
+// CreateGroup create a group, the user that create it is its first admin
+func CreateGroup(userId int, name string) (*Group, error) {
+	group := &Group{
+		Name:      name,
+		CreatedBy: userId,
+		CreatedAt: time.Now(),
+	}
+	if err := db.Save(group).Error; err != nil {
+		return nil, err
+	}
+	member := &GroupMember{
+		GroupId:   group.ID,
+		UserId:    userId,
+		Role:      GroupRoleAdmin,
+		CreatedAt: time.Now(),
+	}
+	if err := db.Save(member).Error; err != nil {
+		return nil, err
+	}
+	group.Role = member.Role
+	return group, nil
+}
+
+func GroupById(groupId int) (*Group, error) {
+	var group *Group
+	err := db.Model(Group{}).Where(groupId).First(&group).Error
+	if err != nil {
+		return nil, err
+	}
+	return group, nil
+}
+
+// GroupsByUserId get the groups of the user with their role in each group
+func GroupsByUserId(userId int) ([]*Group, error) {
+	var members []*GroupMember
+	err := db.Model(GroupMember{}).Where(GroupMember{UserId: userId}).Find(&members).Error
+	if err != nil {
+		return nil, err
+	}
+	groups := make([]*Group, 0, len(members))
+	for _, member := range members {
+		group, err := GroupById(member.GroupId)
+		if err != nil {
+			return nil, err
+		}
+		group.Role = member.Role
+		groups = append(groups, group)
+	}
+	return groups, nil
+}
+
+func GroupMemberById(groupId, userId int) (*GroupMember, error) {
+	var member *GroupMember
+	err := db.Model(GroupMember{}).Where(GroupMember{GroupId: groupId, UserId: userId}).First(&member).Error
+	if err != nil {
+		return nil, err
+	}
+	return member, nil
+}
+
+func GroupMembersByGroupId(groupId int) ([]*GroupMember, error) {
+	var members []*GroupMember
+	err := db.Model(GroupMember{}).Where(GroupMember{GroupId: groupId}).Find(&members).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, member := range members {
+		if user, err := UserById(member.UserId); err == nil {
+			member.Username = user.Username
+		}
+	}
+	return members, nil
+}
+
+// SaveGroupMember add the user to the group, the role is updated if the user is already a member
+func SaveGroupMember(groupId int, user *User, role string) (*GroupMember, error) {
+	member, err := GroupMemberById(groupId, user.ID)
+	if err != nil {
+		member = &GroupMember{
+			GroupId:   groupId,
+			UserId:    user.ID,
+			CreatedAt: time.Now(),
+		}
+	}
+	member.Role = role
+	if err := db.Save(member).Error; err != nil {
+		return nil, err
+	}
+	member.Username = user.Username
+	return member, nil
+}
+
+func RemoveGroupMember(member *GroupMember) error {
+	return db.Delete(GroupMember{}, member.ID).Error
+}
+
+// CountGroupAdmins give the number of admins of the group
+func CountGroupAdmins(groupId int) (int64, error) {
+	var count int64
+	err := db.Model(GroupMember{}).Where(GroupMember{GroupId: groupId, Role: GroupRoleAdmin}).Count(&count).Error
+	return count, err
+}
+
+// RemoveGroup remove the group, its members and its games
+func RemoveGroup(group *Group) error {
+	err := db.Where("game_id IN (?)", db.Model(Game{}).Select("id").Where(Game{GroupId: &group.ID})).Delete(Revision{}).Error
+	if err != nil {
+		return err
+	}
+	err = db.Where("game_id IN (?)", db.Model(Game{}).Select("id").Where(Game{GroupId: &group.ID})).Delete(Screenshot{}).Error
+	if err != nil {
+		return err
+	}
+	err = db.Where("game_id IN (?)", db.Model(Game{}).Select("id").Where(Game{GroupId: &group.ID})).Delete(SavePathTemplate{}).Error
+	if err != nil {
+		return err
+	}
+	err = db.Where("game_id IN (?)", db.Model(Game{}).Select("id").Where(Game{GroupId: &group.ID})).Delete(ShareLink{}).Error
+	if err != nil {
+		return err
+	}
+	if err := db.Delete(Game{}, Game{GroupId: &group.ID}).Error; err != nil {
+		return err
+	}
+	if err := db.Delete(GroupMember{}, GroupMember{GroupId: group.ID}).Error; err != nil {
+		return err
+	}
+	return db.Delete(Group{}, group.ID).Error
+}
+
+func RemoveAllUserGroupMemberships(user *User) error {
+	return db.Delete(GroupMember{}, GroupMember{UserId: user.ID}).Error
+}
+
+func AddShareLink(link *ShareLink) error {
+	if err := db.Save(link).Error; err != nil {
+		return err
+	}
+	link.HasPassword = len(link.Password) > 0
+	return nil
+}
+
+func ShareLinkById(linkId int) (*ShareLink, error) {
+	var link *ShareLink
+	err := db.Model(ShareLink{}).Where(linkId).First(&link).Error
+	if err != nil {
+		return nil, err
+	}
+	link.HasPassword = len(link.Password) > 0
+	return link, nil
+}
+
+// ShareLinksByUserId get all the share links created by the user
+func ShareLinksByUserId(userId int) ([]*ShareLink, error) {
+	var links []*ShareLink
+	err := db.Model(ShareLink{}).Where(ShareLink{UserId: userId}).Order("created_at desc").Find(&links).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, link := range links {
+		link.HasPassword = len(link.Password) > 0
+	}
+	return links, nil
+}
+
+// ShareLinksByGameId get all the share links of a game, whoever created them
+func ShareLinksByGameId(gameId int) ([]*ShareLink, error) {
+	var links []*ShareLink
+	err := db.Model(ShareLink{}).Where(ShareLink{GameId: gameId}).Order("created_at desc").Find(&links).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, link := range links {
+		link.HasPassword = len(link.Password) > 0
+	}
+	return links, nil
+}
+
+// ShareLinksOfUserGames get the share links of all the games of the user
+func ShareLinksOfUserGames(userId int) ([]*ShareLink, error) {
+	var links []*ShareLink
+	err := db.Model(ShareLink{}).Where("game_id IN (?)", db.Model(Game{}).Select("id").Where(Game{UserId: userId})).Find(&links).Error
+	if err != nil {
+		return nil, err
+	}
+	return links, nil
+}
+
+// ShareLinksOfGroupGames get the share links of all the games of the group
+func ShareLinksOfGroupGames(groupId int) ([]*ShareLink, error) {
+	var links []*ShareLink
+	err := db.Model(ShareLink{}).Where("game_id IN (?)", db.Model(Game{}).Select("id").Where(Game{GroupId: &groupId})).Find(&links).Error
+	if err != nil {
+		return nil, err
+	}
+	return links, nil
+}
+
+// ExpiredShareLinks get the share links that are expired or that reached their max downloads
+func ExpiredShareLinks() ([]*ShareLink, error) {
+	var links []*ShareLink
+	err := db.Model(ShareLink{}).Where("expire < ? OR (max_downloads > 0 AND downloads >= max_downloads)", time.Now()).Find(&links).Error
+	if err != nil {
+		return nil, err
+	}
+	return links, nil
+}
+
+// UseShareLink count a download of the link, an error is returned if the max downloads is reached
+func UseShareLink(link *ShareLink) error {
+	result := db.Model(ShareLink{}).
+		Where("id = ? AND (max_downloads = 0 OR downloads < max_downloads)", link.ID).
+		Update("downloads", gorm.Expr("downloads + 1"))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	link.Downloads++
+	return nil
+}
+
+func RemoveShareLink(link *ShareLink) error {
+	return db.Delete(ShareLink{}, link.ID).Error
+}
+
+// CatalogGameById get a catalog game with its store identifiers and its save locations
+func CatalogGameById(catalogId int) (*CatalogGame, error) {
+	var game *CatalogGame
+	err := db.Model(CatalogGame{}).Where(catalogId).First(&game).Error
+	if err != nil {
+		return nil, err
+	}
+	return game, catalogGameDetails(game)
+}
+
+// CatalogGameByNormalizedTitle get the catalog game that match the normalized title
+func CatalogGameByNormalizedTitle(normalizedTitle string) (*CatalogGame, error) {
+	var game *CatalogGame
+	err := db.Model(CatalogGame{}).Where(CatalogGame{NormalizedTitle: normalizedTitle}).First(&game).Error
+	if err != nil {
+		return nil, err
+	}
+	return game, catalogGameDetails(game)
+}
+
+// CatalogGameByStoreId get the catalog game that have the identifier in the store
+func CatalogGameByStoreId(store, storeId string) (*CatalogGame, error) {
+	var id *CatalogStoreId
+	err := db.Model(CatalogStoreId{}).Where(CatalogStoreId{Store: store, StoreId: storeId}).First(&id).Error
+	if err != nil {
+		return nil, err
+	}
+	return CatalogGameById(id.CatalogGameId)
+}
+
+// SearchCatalogGames find the catalog games that contains the normalized query in their title,
+// the normalized query only have letters and digits
+func SearchCatalogGames(normalizedQuery string, limit int) ([]*CatalogGame, error) {
+	var games []*CatalogGame
+	err := db.Model(CatalogGame{}).
+		Where("normalized_title LIKE ?", "%"+normalizedQuery+"%").
+		Order("title").Limit(limit).Find(&games).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, game := range games {
+		if err := catalogGameDetails(game); err != nil {
+			return nil, err
+		}
+	}
+	return games, nil
+}
+
+// SaveCatalogGame save a catalog game and replace its store identifiers and its save locations
+func SaveCatalogGame(game *CatalogGame) error {
+	game.Platforms = strings.Join(game.PlatformList, " ")
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(game).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(CatalogStoreId{}, CatalogStoreId{CatalogGameId: game.ID}).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(CatalogSaveLocation{}, CatalogSaveLocation{CatalogGameId: game.ID}).Error; err != nil {
+			return err
+		}
+		for _, id := range game.StoreIds {
+			id.ID = 0
+			id.CatalogGameId = game.ID
+			if err := tx.Save(id).Error; err != nil {
+				return err
+			}
+		}
+		for _, location := range game.SaveLocations {
+			location.ID = 0
+			location.CatalogGameId = game.ID
+			if err := tx.Save(location).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SaveCatalogGames save the catalog games and replace their store identifiers and their save locations, the rows
+// are inserted in batches
+func SaveCatalogGames(games []*CatalogGame) error {
+	if len(games) == 0 {
+		return nil
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		var created []*CatalogGame
+		gameIds := make([]int, 0, len(games))
+		for _, game := range games {
+			game.Platforms = strings.Join(game.PlatformList, " ")
+			if game.ID == 0 {
+				created = append(created, game)
+				continue
+			}
+			if err := tx.Save(game).Error; err != nil {
+				return err
+			}
+			gameIds = append(gameIds, game.ID)
+		}
+		if len(created) > 0 {
+			if err := tx.Create(&created).Error; err != nil {
+				return err
+			}
+		}
+		if len(gameIds) > 0 {
+			if err := tx.Where("catalog_game_id IN ?", gameIds).Delete(CatalogStoreId{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("catalog_game_id IN ?", gameIds).Delete(CatalogSaveLocation{}).Error; err != nil {
+				return err
+			}
+		}
+		var storeIds []*CatalogStoreId
+		var locations []*CatalogSaveLocation
+		for _, game := range games {
+			for _, id := range game.StoreIds {
+				id.ID = 0
+				id.CatalogGameId = game.ID
+				storeIds = append(storeIds, id)
+			}
+			for _, location := range game.SaveLocations {
+				location.ID = 0
+				location.CatalogGameId = game.ID
+				locations = append(locations, location)
+			}
+		}
+		if len(storeIds) > 0 {
+			if err := tx.CreateInBatches(storeIds, 500).Error; err != nil {
+				return err
+			}
+		}
+		if len(locations) > 0 {
+			if err := tx.CreateInBatches(locations, 500).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// RemoveCatalogGame remove a catalog game, the games of the users linked to it are kept but unlinked
+func RemoveCatalogGame(game *CatalogGame) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(Game{}).Where("catalog_id = ?", game.ID).Update("catalog_id", nil).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(CatalogStoreId{}, CatalogStoreId{CatalogGameId: game.ID}).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(CatalogSaveLocation{}, CatalogSaveLocation{CatalogGameId: game.ID}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(CatalogGame{}, game.ID).Error
+	})
+}
+
+func catalogGameDetails(game *CatalogGame) error {
+	game.PlatformList = strings.Fields(game.Platforms)
+	if err := db.Model(CatalogStoreId{}).Where(CatalogStoreId{CatalogGameId: game.ID}).Find(&game.StoreIds).Error; err != nil {
+		return err
+	}
+	return db.Model(CatalogSaveLocation{}).Where(CatalogSaveLocation{CatalogGameId: game.ID}).Find(&game.SaveLocations).Error
+}
+
+// RevisionsByGameId get the revisions of a game, the most recent first
+func RevisionsByGameId(gameId int) ([]*Revision, error) {
+	var revisions []*Revision
+	err := db.Model(Revision{}).Where(Revision{GameId: gameId}).Order("revision desc").Find(&revisions).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, revision := range revisions {
+		revision.LabelList = splitLabels(revision.Labels)
+	}
+	return revisions, nil
+}
+
+func RevisionByNumber(gameId, revisionNumber int) (*Revision, error) {
+	var revision *Revision
+	err := db.Model(Revision{}).Where(Revision{GameId: gameId, Revision: revisionNumber}).First(&revision).Error
+	if err != nil {
+		return nil, err
+	}
+	revision.LabelList = splitLabels(revision.Labels)
+	return revision, nil
+}
+
+func SaveRevision(revision *Revision) error {
+	if revision.LabelList == nil {
+		revision.LabelList = []string{}
+	}
+	revision.Labels = strings.Join(revision.LabelList, ",")
+	return db.Save(revision).Error
+}
+
+func splitLabels(labels string) []string {
+	list := make([]string, 0)
+	for _, label := range strings.Split(labels, ",") {
+		if label = strings.TrimSpace(label); len(label) > 0 {
+			list = append(list, label)
+		}
+	}
+	return list
+}
+
+// SaveScreenshot save a screenshot, it replaces the screenshot of the same game and revision
+func SaveScreenshot(screenshot *Screenshot) error {
+	err := db.Where("game_id = ? AND revision = ?", screenshot.GameId, screenshot.Revision).Delete(Screenshot{}).Error
+	if err != nil {
+		return err
+	}
+	return db.Save(screenshot).Error
+}
+
+// ScreenshotByRevision get the screenshot of a revision, the revision 0 is the screenshot of the game
+func ScreenshotByRevision(gameId, revision int) (*Screenshot, error) {
+	var screenshot *Screenshot
+	err := db.Model(Screenshot{}).Where("game_id = ? AND revision = ?", gameId, revision).First(&screenshot).Error
+	if err != nil {
+		return nil, err
+	}
+	return screenshot, nil
+}
+
+func ScreenshotsByGameId(gameId int) ([]*Screenshot, error) {
+	var screenshots []*Screenshot
+	err := db.Model(Screenshot{}).Where(Screenshot{GameId: gameId}).Find(&screenshots).Error
+	if err != nil {
+		return nil, err
+	}
+	return screenshots, nil
+}
+
+// ScreenshotsByGameIds get the screenshots of several games in one query
+func ScreenshotsByGameIds(gameIds []int) ([]*Screenshot, error) {
+	var screenshots []*Screenshot
+	if len(gameIds) == 0 {
+		return screenshots, nil
+	}
+	err := db.Model(Screenshot{}).Where("game_id IN ?", gameIds).Find(&screenshots).Error
+	if err != nil {
+		return nil, err
+	}
+	return screenshots, nil
+}
+
+func RemoveScreenshot(screenshot *Screenshot) error {
+	return db.Delete(Screenshot{}, screenshot.ID).Error
+}
+
+// SavePathTemplatesByGameId get the save path templates of a game for all the OS
+func SavePathTemplatesByGameId(gameId int) ([]*SavePathTemplate, error) {
+	var templates []*SavePathTemplate
+	err := db.Model(SavePathTemplate{}).Where(SavePathTemplate{GameId: gameId}).Order("os, id").Find(&templates).Error
+	if err != nil {
+		return nil, err
+	}
+	return templates, nil
+}
+
+// SetSavePathTemplates replace the save path templates of a game for an OS
+func SetSavePathTemplates(gameId int, os string, templates []*SavePathTemplate) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("game_id = ? AND os = ?", gameId, os).Delete(SavePathTemplate{}).Error
+		if err != nil {
+			return err
+		}
+		for _, template := range templates {
+			template.GameId = gameId
+			template.Os = os
+			if err := tx.Save(template).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
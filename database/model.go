@@ -3,20 +3,275 @@ package database
 import "time"
 
 type User struct {
-	Username string `json:"username"`
-	Role     string `json:"role"`
-	Password []byte `json:"-"`
-	ID       int    `json:"id"`
-	IsAdmin  bool   `json:"is_admin" gorm:"-:all"`
+	Username     string  `json:"username"`
+	Role         string  `json:"role"`
+	Password     []byte  `json:"-"`
+	ID           int     `json:"id"`
+	IsAdmin      bool    `json:"is_admin" gorm:"-:all"`
+	TotpSecret   *string `json:"-"`
+	TotpEnabled  bool    `json:"totp_enabled"`
+	TotpLastStep int64   `json:"-"`
+	OidcSubject  *string `json:"-"`
+	LdapDn       *string `json:"-"`
+	StorageQuota *int64  `json:"storage_quota"`
 }
 
 type Game struct {
-	Name        string     `json:"name"`
-	PathStorage string     `json:"-"`
-	ID          int        `json:"id"`
-	Revision    int        `json:"rev"`
-	UserId      int        `json:"-"`
-	Available   bool       `json:"available"`
-	Hash        *string    `json:"hash"`
-	LastUpdate  *time.Time `json:"last_update"`
+	Name         string              `json:"name"`
+	PathStorage  string              `json:"-"`
+	ID           int                 `json:"id"`
+	Revision     int                 `json:"rev"`
+	UserId       int                 `json:"-"`
+	Available    bool                `json:"available"`
+	Hash         *string             `json:"hash"`
+	LastUpdate   *time.Time          `json:"last_update"`
+	GroupId      *int                `json:"group_id,omitempty"`
+	CatalogId    *int                `json:"catalog_id,omitempty"`
+	Notes        string              `json:"notes"`
+	Owner        string              `json:"owner,omitempty" gorm:"-:all"`
+	Group        string              `json:"group,omitempty" gorm:"-:all"`
+	ShareLevel   string              `json:"share_level,omitempty" gorm:"-:all"`
+	Revisions    []*Revision         `json:"revisions,omitempty" gorm:"-:all"`
+	SavePaths    []*SavePathTemplate `json:"save_paths,omitempty" gorm:"-:all"`
+	ThumbnailUrl string              `json:"thumbnail_url,omitempty" gorm:"-:all"`
+}
+
+type RefreshToken struct {
+	ID        int
+	UserId    int
+	SessionId string
+	Hash      string
+	Expire    time.Time
+	CreatedAt time.Time
+}
+
+type Session struct {
+	ID        string    `json:"id"`
+	UserId    int       `json:"user_id"`
+	Device    string    `json:"device"`
+	Ip        string    `json:"ip"`
+	CreatedAt time.Time `json:"created_at"`
+	LastSeen  time.Time `json:"last_seen"`
+	Expire    time.Time `json:"expire"`
+	Current   bool      `json:"current" gorm:"-:all"`
+}
+
+type PersonalAccessToken struct {
+	ID        int        `json:"id"`
+	UserId    int        `json:"-"`
+	Name      string     `json:"name"`
+	Hash      string     `json:"-"`
+	Scopes    string     `json:"-"`
+	ScopeList []string   `json:"scopes" gorm:"-:all"`
+	Expire    *time.Time `json:"expire"`
+	CreatedAt time.Time  `json:"created_at"`
+	LastUsed  *time.Time `json:"last_used"`
+}
+
+type RecoveryCode struct {
+	ID     int
+	UserId int
+	Hash   string
+}
+
+type PasswordHistory struct {
+	ID        int
+	UserId    int
+	Hash      []byte
+	CreatedAt time.Time
+}
+
+type Invitation struct {
+	ID           int        `json:"id"`
+	Hash         string     `json:"-"`
+	Note         string     `json:"note"`
+	Role         string     `json:"role"`
+	MaxUses      int        `json:"max_uses"`
+	Uses         int        `json:"uses"`
+	StorageQuota *int64     `json:"storage_quota"`
+	Expire       *time.Time `json:"expire"`
+	Revoked      bool       `json:"revoked"`
+	CreatedBy    int        `json:"created_by"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+type PasswordReset struct {
+	ID        int
+	UserId    int
+	Hash      string
+	Expire    time.Time
+	UsedAt    *time.Time
+	CreatedBy int
+	CreatedAt time.Time
+}
+
+type AuditEvent struct {
+	ID        int       `json:"id"`
+	Action    string    `json:"action"`
+	ActorId   int       `json:"actor_id"`
+	UserId    int       `json:"user_id"`
+	Ip        string    `json:"ip"`
+	Detail    string    `json:"detail"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Role give a set of permissions to the users, the permission "*" give all of them
+type Role struct {
+	ID             int      `json:"id"`
+	Name           string   `json:"name"`
+	Description    string   `json:"description"`
+	Permissions    string   `json:"-"`
+	PermissionList []string `json:"permissions" gorm:"-:all"`
+	BuiltIn        bool     `json:"built_in"`
+}
+
+// GameShare give to another user the access to the game save of the owner
+type GameShare struct {
+	ID        int       `json:"id"`
+	GameId    int       `json:"game_id"`
+	OwnerId   int       `json:"-"`
+	UserId    int       `json:"user_id"`
+	Username  string    `json:"username" gorm:"-:all"`
+	Level     string    `json:"level"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Group own a library of games jointly, the role of the members control who can upload
+type Group struct {
+	ID          int            `json:"id"`
+	Name        string         `json:"name"`
+	CreatedBy   int            `json:"-"`
+	CreatedAt   time.Time      `json:"created_at"`
+	Role        string         `json:"role,omitempty" gorm:"-:all"`
+	Members     []*GroupMember `json:"members,omitempty" gorm:"-:all"`
+	StorageUsed int64          `json:"storage_used" gorm:"-:all"`
+}
+
+type GroupMember struct {
+	ID        int       `json:"-"`
+	GroupId   int       `json:"-"`
+	UserId    int       `json:"user_id"`
+	Username  string    `json:"username" gorm:"-:all"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ShareLink give a public access to a snapshot of a revision of a game save
+type ShareLink struct {
+	ID           int       `json:"id"`
+	GameId       int       `json:"game_id"`
+	UserId       int       `json:"-"`
+	Revision     int       `json:"rev"`
+	Hash         string    `json:"hash"`
+	PathStorage  string    `json:"-"`
+	Password     []byte    `json:"-"`
+	HasPassword  bool      `json:"has_password" gorm:"-:all"`
+	MaxDownloads int       `json:"max_downloads"`
+	Downloads    int       `json:"downloads"`
+	Expire       time.Time `json:"expire"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// CatalogGame is the canonical metadata of a game, shared by all the users of the server
+type CatalogGame struct {
+	ID              int                    `json:"id"`
+	Title           string                 `json:"title"`
+	NormalizedTitle string                 `json:"-"`
+	Platforms       string                 `json:"-"`
+	PlatformList    []string               `json:"platforms" gorm:"-:all"`
+	StoreIds        []*CatalogStoreId      `json:"store_ids" gorm:"-:all"`
+	SaveLocations   []*CatalogSaveLocation `json:"save_locations" gorm:"-:all"`
+	CreatedAt       time.Time              `json:"created_at"`
+	UpdatedAt       time.Time              `json:"updated_at"`
+}
+
+// CatalogStoreId is the identifier of a catalog game in a store, like the app ID of Steam
+type CatalogStoreId struct {
+	ID            int    `json:"-"`
+	CatalogGameId int    `json:"-"`
+	Store         string `json:"store"`
+	StoreId       string `json:"store_id"`
+}
+
+// CatalogSaveLocation is a known location of the saves of a catalog game, an empty OS match all of them
+// and an empty store match all the stores
+type CatalogSaveLocation struct {
+	ID            int    `json:"-"`
+	CatalogGameId int    `json:"-"`
+	Os            string `json:"os"`
+	Store         string `json:"store,omitempty"`
+	Path          string `json:"path"`
+}
+
+// Revision describe an upload of a game save. A pinned revision is excluded from the automatic pruning
+type Revision struct {
+	ID           int       `json:"-"`
+	GameId       int       `json:"-"`
+	Revision     int       `json:"rev"`
+	Hash         string    `json:"hash"`
+	UserId       int       `json:"user_id"`
+	Device       string    `json:"device"`
+	Note         string    `json:"note"`
+	Labels       string    `json:"-"`
+	LabelList    []string  `json:"labels" gorm:"-:all"`
+	Pinned       bool      `json:"pinned"`
+	CreatedAt    time.Time `json:"created_at"`
+	ThumbnailUrl string    `json:"thumbnail_url,omitempty" gorm:"-:all"`
+}
+
+// Screenshot of a game, or of a revision when Revision is not 0. Name is the base name of the files
+type Screenshot struct {
+	ID        int       `json:"-"`
+	GameId    int       `json:"game_id"`
+	Revision  int       `json:"rev"`
+	Name      string    `json:"-"`
+	Width     int       `json:"width"`
+	Height    int       `json:"height"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+const (
+	SortId         string = "id"
+	SortName       string = "name"
+	SortLastUpdate string = "last_update"
+)
+
+// Page sort and cut a list. The cursor is the sort value and the ID of the last item of the previous page,
+// a limit of 0 get the whole list
+type Page struct {
+	Sort   string
+	Desc   bool
+	Cursor *Cursor
+	Limit  int
+}
+
+type Cursor struct {
+	Value string `json:"v,omitempty"`
+	Id    int    `json:"id"`
+}
+
+// GameFilter is the page of a list of games, the games are filtered by name, availability and date of the last update
+type GameFilter struct {
+	Page
+	Search        string
+	Available     *bool
+	UpdatedAfter  *time.Time
+	UpdatedBefore *time.Time
+}
+
+// UserFilter is the page of a list of users, the users are filtered by username and role
+type UserFilter struct {
+	Page
+	Search string
+	Role   string
+}
+
+// SavePathTemplate is the save folder of a game on an OS. The path has variables like {home} that each client
+// replaces with its own folders. A template from the catalog is not stored, it is the default of the game
+type SavePathTemplate struct {
+	ID          int    `json:"-"`
+	GameId      int    `json:"-"`
+	Os          string `json:"os"`
+	Path        string `json:"path"`
+	FromCatalog bool   `json:"from_catalog,omitempty" gorm:"-:all"`
 }
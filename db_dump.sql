@@ -18,6 +18,73 @@
 -- Dumping database structure for osc
 USE `osc`;
 
+-- Dumping structure for table osc.audit_events
+CREATE TABLE IF NOT EXISTS `audit_events` (
+  `id` bigint unsigned NOT NULL AUTO_INCREMENT,
+  `action` varchar(50) NOT NULL,
+  `actor_id` bigint unsigned NOT NULL DEFAULT '0',
+  `user_id` bigint unsigned NOT NULL DEFAULT '0',
+  `ip` varchar(45) NOT NULL DEFAULT '',
+  `detail` text NOT NULL,
+  `created_at` datetime NOT NULL,
+  PRIMARY KEY (`id`)
+) ENGINE=MyISAM AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb3;
+
+-- Data exporting was unselected.
+
+-- Dumping structure for table osc.catalog_games
+CREATE TABLE IF NOT EXISTS `catalog_games` (
+  `id` bigint unsigned NOT NULL AUTO_INCREMENT,
+  `title` varchar(255) NOT NULL,
+  `normalized_title` varchar(255) NOT NULL,
+  `platforms` text NOT NULL,
+  `created_at` datetime NOT NULL,
+  `updated_at` datetime NOT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `normalized_title` (`normalized_title`)
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb3;
+
+-- Data exporting was unselected.
+
+-- Dumping structure for table osc.catalog_save_locations
+CREATE TABLE IF NOT EXISTS `catalog_save_locations` (
+  `id` bigint unsigned NOT NULL AUTO_INCREMENT,
+  `catalog_game_id` bigint unsigned NOT NULL DEFAULT '0',
+  `os` varchar(20) NOT NULL DEFAULT '',
+  `store` varchar(20) NOT NULL DEFAULT '',
+  `path` text NOT NULL,
+  PRIMARY KEY (`id`),
+  KEY `catalog_game_id` (`catalog_game_id`)
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb3;
+
+-- Data exporting was unselected.
+
+-- Dumping structure for table osc.catalog_store_ids
+CREATE TABLE IF NOT EXISTS `catalog_store_ids` (
+  `id` bigint unsigned NOT NULL AUTO_INCREMENT,
+  `catalog_game_id` bigint unsigned NOT NULL DEFAULT '0',
+  `store` varchar(20) NOT NULL,
+  `store_id` varchar(100) NOT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `store_id` (`store`,`store_id`)
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb3;
+
+-- Data exporting was unselected.
+
+-- Dumping structure for table osc.game_shares
+CREATE TABLE IF NOT EXISTS `game_shares` (
+  `id` bigint unsigned NOT NULL AUTO_INCREMENT,
+  `game_id` bigint unsigned NOT NULL DEFAULT '0',
+  `owner_id` bigint unsigned NOT NULL DEFAULT '0',
+  `user_id` bigint unsigned NOT NULL DEFAULT '0',
+  `level` varchar(10) NOT NULL DEFAULT 'read',
+  `created_at` datetime NOT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `game_user` (`game_id`,`user_id`)
+) ENGINE=MyISAM AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb3;
+
+-- Data exporting was unselected.
+
 -- Dumping structure for table osc.games
 CREATE TABLE IF NOT EXISTS `games` (
   `id` bigint unsigned NOT NULL AUTO_INCREMENT,
@@ -28,6 +95,214 @@ CREATE TABLE IF NOT EXISTS `games` (
   `last_update` datetime DEFAULT NULL,
   `user_id` bigint unsigned NOT NULL DEFAULT '0',
   `available` tinyint unsigned NOT NULL DEFAULT '0',
+  `group_id` bigint unsigned DEFAULT NULL,
+  `catalog_id` bigint unsigned DEFAULT NULL,
+  `notes` text NOT NULL,
+  PRIMARY KEY (`id`)
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb3;
+
+-- Data exporting was unselected.
+
+-- Dumping structure for table osc.groups
+CREATE TABLE IF NOT EXISTS `groups` (
+  `id` bigint unsigned NOT NULL AUTO_INCREMENT,
+  `name` varchar(255) NOT NULL,
+  `created_by` bigint unsigned NOT NULL DEFAULT '0',
+  `created_at` datetime NOT NULL,
+  PRIMARY KEY (`id`)
+) ENGINE=MyISAM AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb3;
+
+-- Data exporting was unselected.
+
+-- Dumping structure for table osc.group_members
+CREATE TABLE IF NOT EXISTS `group_members` (
+  `id` bigint unsigned NOT NULL AUTO_INCREMENT,
+  `group_id` bigint unsigned NOT NULL DEFAULT '0',
+  `user_id` bigint unsigned NOT NULL DEFAULT '0',
+  `role` varchar(10) NOT NULL DEFAULT 'member',
+  `created_at` datetime NOT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `group_user` (`group_id`,`user_id`)
+) ENGINE=MyISAM AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb3;
+
+-- Data exporting was unselected.
+
+-- Dumping structure for table osc.invitations
+CREATE TABLE IF NOT EXISTS `invitations` (
+  `id` bigint unsigned NOT NULL AUTO_INCREMENT,
+  `hash` char(64) NOT NULL,
+  `note` varchar(255) NOT NULL DEFAULT '',
+  `role` varchar(50) NOT NULL DEFAULT 'user',
+  `max_uses` int unsigned NOT NULL DEFAULT '1',
+  `uses` int unsigned NOT NULL DEFAULT '0',
+  `storage_quota` bigint unsigned DEFAULT NULL,
+  `expire` datetime DEFAULT NULL,
+  `revoked` tinyint unsigned NOT NULL DEFAULT '0',
+  `created_by` bigint unsigned NOT NULL DEFAULT '0',
+  `created_at` datetime NOT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `hash` (`hash`)
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb3;
+
+-- Data exporting was unselected.
+
+-- Dumping structure for table osc.password_histories
+CREATE TABLE IF NOT EXISTS `password_histories` (
+  `id` bigint unsigned NOT NULL AUTO_INCREMENT,
+  `user_id` bigint unsigned NOT NULL DEFAULT '0',
+  `hash` binary(60) NOT NULL,
+  `created_at` datetime NOT NULL,
+  PRIMARY KEY (`id`)
+) ENGINE=MyISAM AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb3;
+
+-- Data exporting was unselected.
+
+-- Dumping structure for table osc.password_resets
+CREATE TABLE IF NOT EXISTS `password_resets` (
+  `id` bigint unsigned NOT NULL AUTO_INCREMENT,
+  `user_id` bigint unsigned NOT NULL DEFAULT '0',
+  `hash` char(64) NOT NULL,
+  `expire` datetime NOT NULL,
+  `used_at` datetime DEFAULT NULL,
+  `created_by` bigint unsigned NOT NULL DEFAULT '0',
+  `created_at` datetime NOT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `hash` (`hash`)
+) ENGINE=MyISAM AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb3;
+
+-- Data exporting was unselected.
+
+-- Dumping structure for table osc.personal_access_tokens
+CREATE TABLE IF NOT EXISTS `personal_access_tokens` (
+  `id` bigint unsigned NOT NULL AUTO_INCREMENT,
+  `user_id` bigint unsigned NOT NULL DEFAULT '0',
+  `name` varchar(255) NOT NULL,
+  `hash` char(64) NOT NULL,
+  `scopes` text NOT NULL,
+  `expire` datetime DEFAULT NULL,
+  `created_at` datetime NOT NULL,
+  `last_used` datetime DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `hash` (`hash`)
+) ENGINE=MyISAM AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb3;
+
+-- Data exporting was unselected.
+
+-- Dumping structure for table osc.recovery_codes
+CREATE TABLE IF NOT EXISTS `recovery_codes` (
+  `id` bigint unsigned NOT NULL AUTO_INCREMENT,
+  `user_id` bigint unsigned NOT NULL DEFAULT '0',
+  `hash` char(64) NOT NULL,
+  PRIMARY KEY (`id`)
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb3;
+
+-- Data exporting was unselected.
+
+-- Dumping structure for table osc.refresh_tokens
+CREATE TABLE IF NOT EXISTS `refresh_tokens` (
+  `id` bigint unsigned NOT NULL AUTO_INCREMENT,
+  `user_id` bigint unsigned NOT NULL DEFAULT '0',
+  `session_id` char(36) NOT NULL,
+  `hash` char(64) NOT NULL,
+  `expire` datetime NOT NULL,
+  `created_at` datetime NOT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `hash` (`hash`)
+) ENGINE=MyISAM AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb3;
+
+-- Data exporting was unselected.
+
+-- Dumping structure for table osc.revisions
+CREATE TABLE IF NOT EXISTS `revisions` (
+  `id` bigint unsigned NOT NULL AUTO_INCREMENT,
+  `game_id` bigint unsigned NOT NULL DEFAULT '0',
+  `revision` bigint unsigned NOT NULL DEFAULT '0',
+  `hash` varchar(50) NOT NULL DEFAULT '',
+  `user_id` bigint unsigned NOT NULL DEFAULT '0',
+  `device` varchar(255) NOT NULL DEFAULT '',
+  `note` text NOT NULL,
+  `labels` text NOT NULL,
+  `pinned` tinyint unsigned NOT NULL DEFAULT '0',
+  `created_at` datetime NOT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `game_revision` (`game_id`,`revision`)
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb3;
+
+-- Data exporting was unselected.
+
+-- Dumping structure for table osc.roles
+CREATE TABLE IF NOT EXISTS `roles` (
+  `id` bigint unsigned NOT NULL AUTO_INCREMENT,
+  `name` varchar(50) NOT NULL,
+  `description` varchar(255) NOT NULL DEFAULT '',
+  `permissions` text NOT NULL,
+  `built_in` tinyint unsigned NOT NULL DEFAULT '0',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `name` (`name`)
+) ENGINE=MyISAM AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb3;
+
+-- Dumping data for table osc.roles
+INSERT IGNORE INTO `roles` (`name`, `description`, `permissions`, `built_in`) VALUES
+	('admin', 'Full access to the administration', '*', 1),
+	('user', 'Regular user without administration access', '', 1),
+	('user_manager', 'Manage the users, their sessions and the invitations', 'users:read users:write sessions:manage security:manage invitations:manage', 0),
+	('auditor', 'Read-only access to the users and the audit log', 'users:read audit:read', 0),
+	('storage_operator', 'Read the users, their storage usage and manage their storage quotas', 'users:read storage:manage', 0);
+
+-- Dumping structure for table osc.save_path_templates
+CREATE TABLE IF NOT EXISTS `save_path_templates` (
+  `id` bigint unsigned NOT NULL AUTO_INCREMENT,
+  `game_id` bigint unsigned NOT NULL DEFAULT '0',
+  `os` varchar(20) NOT NULL,
+  `path` text NOT NULL,
+  PRIMARY KEY (`id`),
+  KEY `game_id` (`game_id`)
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb3;
+
+-- Data exporting was unselected.
+
+-- Dumping structure for table osc.screenshots
+CREATE TABLE IF NOT EXISTS `screenshots` (
+  `id` bigint unsigned NOT NULL AUTO_INCREMENT,
+  `game_id` bigint unsigned NOT NULL DEFAULT '0',
+  `revision` bigint unsigned NOT NULL DEFAULT '0',
+  `name` varchar(255) NOT NULL,
+  `width` int unsigned NOT NULL DEFAULT '0',
+  `height` int unsigned NOT NULL DEFAULT '0',
+  `created_at` datetime NOT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `game_revision` (`game_id`,`revision`)
+) ENGINE=MyISAM AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb3;
+
+-- Data exporting was unselected.
+
+-- Dumping structure for table osc.sessions
+CREATE TABLE IF NOT EXISTS `sessions` (
+  `id` char(36) NOT NULL,
+  `user_id` bigint unsigned NOT NULL DEFAULT '0',
+  `device` varchar(255) NOT NULL DEFAULT '',
+  `ip` varchar(45) NOT NULL DEFAULT '',
+  `created_at` datetime NOT NULL,
+  `last_seen` datetime NOT NULL,
+  `expire` datetime NOT NULL,
+  PRIMARY KEY (`id`)
+) ENGINE=MyISAM DEFAULT CHARSET=utf8mb3;
+
+-- Data exporting was unselected.
+
+-- Dumping structure for table osc.share_links
+CREATE TABLE IF NOT EXISTS `share_links` (
+  `id` bigint unsigned NOT NULL AUTO_INCREMENT,
+  `game_id` bigint unsigned NOT NULL DEFAULT '0',
+  `user_id` bigint unsigned NOT NULL DEFAULT '0',
+  `revision` bigint unsigned NOT NULL DEFAULT '0',
+  `hash` varchar(50) NOT NULL DEFAULT '',
+  `path_storage` text NOT NULL,
+  `password` binary(60) DEFAULT NULL,
+  `max_downloads` int unsigned NOT NULL DEFAULT '0',
+  `downloads` int unsigned NOT NULL DEFAULT '0',
+  `expire` datetime NOT NULL,
+  `created_at` datetime NOT NULL,
   PRIMARY KEY (`id`)
 ) ENGINE=MyISAM AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb3;
 
@@ -38,9 +313,16 @@ CREATE TABLE IF NOT EXISTS `users` (
   `id` bigint unsigned NOT NULL AUTO_INCREMENT,
   `username` varchar(50) NOT NULL,
   `password` binary(60) NOT NULL,
-  `role` varchar(10) NOT NULL DEFAULT 'user',
-  PRIMARY KEY (`id`)
-) ENGINE=MyISAM AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb3;
+  `role` varchar(50) NOT NULL DEFAULT 'user',
+  `totp_secret` varchar(64) DEFAULT NULL,
+  `totp_enabled` tinyint unsigned NOT NULL DEFAULT '0',
+  `totp_last_step` bigint NOT NULL DEFAULT '0',
+  `oidc_subject` varchar(255) DEFAULT NULL,
+  `ldap_dn` varchar(255) DEFAULT NULL,
+  `storage_quota` bigint unsigned DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `oidc_subject` (`oidc_subject`)
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb3;
 
 -- Data exporting was unselected.
 
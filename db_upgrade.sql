@@ -0,0 +1,62 @@
+-- --------------------------------------------------------
+-- Upgrade of a database created with a previous db_dump.sql.
+-- The new tables are created by db_dump.sql (CREATE TABLE IF NOT EXISTS), so run it first,
+-- then run this file once to add the new columns to the tables that already exist and to move
+-- to InnoDB the tables that are written in transactions.
+-- --------------------------------------------------------
+
+USE `osc`;
+
+-- Upgrading structure for table osc.users (two-factor authentication)
+ALTER TABLE `users`
+  ADD COLUMN `totp_secret` varchar(64) DEFAULT NULL AFTER `role`,
+  ADD COLUMN `totp_enabled` tinyint unsigned NOT NULL DEFAULT '0' AFTER `totp_secret`,
+  ADD COLUMN `totp_last_step` bigint NOT NULL DEFAULT '0' AFTER `totp_enabled`;
+
+-- The recovery codes are replaced in a transaction
+ALTER TABLE `recovery_codes` ENGINE=InnoDB;
+
+-- Upgrading structure for table osc.users (OpenID Connect)
+ALTER TABLE `users`
+  ADD COLUMN `oidc_subject` varchar(255) DEFAULT NULL AFTER `totp_last_step`,
+  ADD UNIQUE KEY `oidc_subject` (`oidc_subject`);
+
+-- Upgrading structure for table osc.users (LDAP)
+ALTER TABLE `users`
+  ADD COLUMN `ldap_dn` varchar(255) DEFAULT NULL AFTER `oidc_subject`;
+
+-- Upgrading structure for table osc.users (roles), the names of the roles are longer than the built-in ones
+ALTER TABLE `users`
+  MODIFY COLUMN `role` varchar(50) NOT NULL DEFAULT 'user';
+
+-- Upgrading structure for table osc.games (groups)
+ALTER TABLE `games`
+  ADD COLUMN `group_id` bigint unsigned DEFAULT NULL AFTER `available`;
+
+-- Upgrading structure for table osc.games (catalog)
+ALTER TABLE `games`
+  ADD COLUMN `catalog_id` bigint unsigned DEFAULT NULL AFTER `group_id`;
+
+-- A catalog game and its rules are saved in a transaction
+ALTER TABLE `catalog_games` ENGINE=InnoDB;
+ALTER TABLE `catalog_save_locations` ENGINE=InnoDB;
+ALTER TABLE `catalog_store_ids` ENGINE=InnoDB;
+
+-- Upgrading structure for table osc.games (notes)
+ALTER TABLE `games`
+  ADD COLUMN `notes` text NOT NULL AFTER `catalog_id`;
+
+-- The history of a merged game is moved in a transaction
+ALTER TABLE `games` ENGINE=InnoDB;
+ALTER TABLE `revisions` ENGINE=InnoDB;
+
+-- The save path templates of an OS are replaced in a transaction
+ALTER TABLE `save_path_templates` ENGINE=InnoDB;
+
+-- Upgrading structure for table osc.users (storage quota), NULL is an unlimited storage
+ALTER TABLE `users`
+  ADD COLUMN `storage_quota` bigint unsigned DEFAULT NULL AFTER `ldap_dn`;
+
+-- A user registered with an invitation is created in the same transaction as the use of the invitation
+ALTER TABLE `users` ENGINE=InnoDB;
+
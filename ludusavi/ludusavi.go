@@ -0,0 +1,342 @@
+package ludusavi
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"log"
+	"opensavecloudserver/catalog"
+	"opensavecloudserver/database"
+	"opensavecloudserver/upload"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// mapping is the mapping.yaml file of a game in a Ludusavi backup folder
+type mapping struct {
+	Name    string            `yaml:"name"`
+	Drives  map[string]string `yaml:"drives"`
+	Backups []struct {
+		Name     string                  `yaml:"name"`
+		Files    map[string]*mappingFile `yaml:"files"`
+		Children []struct {
+			Name  string                  `yaml:"name"`
+			Files map[string]*mappingFile `yaml:"files"`
+		} `yaml:"children"`
+	} `yaml:"backups"`
+}
+
+// mappingFile is a file of a backup, a differential backup give a nil file when the file was removed
+type mappingFile struct {
+	Hash string `yaml:"hash"`
+	Size int64  `yaml:"size"`
+}
+
+// source is where the content of a file is found, in the folder or in the zip archive of a backup
+type source struct {
+	backup string
+	name   string
+}
+
+// revision is the state of the files of the game after a backup
+type revision struct {
+	name  string
+	files map[string]source
+}
+
+// Result count what was imported, the games that cannot be imported are reported in Skipped
+type Result struct {
+	Games     int      `json:"games"`
+	Revisions int      `json:"revisions"`
+	Skipped   []string `json:"skipped"`
+}
+
+// Import walk a Ludusavi backup folder and import each game for the user. A game is created for each
+// backup, then each backup, from the oldest to the newest, is uploaded as a revision of the game.
+// A game that already exists with a save is skipped, so the same folder can be imported again
+func Import(username, root string) (*Result, error) {
+	user, err := database.UserByUsername(username)
+	if err != nil {
+		return nil, fmt.Errorf("the user '%s' does not exist", username)
+	}
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
+	result := &Result{
+		Skipped: []string{},
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		gameFolder := filepath.Join(root, entry.Name())
+		if _, err := os.Stat(filepath.Join(gameFolder, "mapping.yaml")); err != nil {
+			continue
+		}
+		count, err := importGame(user, gameFolder)
+		if err != nil {
+			log.Printf("%s: %s", entry.Name(), err)
+			result.Skipped = append(result.Skipped, fmt.Sprintf("%s: %s", entry.Name(), err))
+			continue
+		}
+		result.Games++
+		result.Revisions += count
+	}
+	return result, nil
+}
+
+func importGame(user *database.User, gameFolder string) (int, error) {
+	content, err := os.ReadFile(filepath.Join(gameFolder, "mapping.yaml"))
+	if err != nil {
+		return 0, err
+	}
+	m := new(mapping)
+	if err := yaml.Unmarshal(content, m); err != nil {
+		return 0, err
+	}
+	if len(strings.TrimSpace(m.Name)) == 0 {
+		return 0, errors.New("the mapping does not have a game name")
+	}
+	revisions, err := m.revisions()
+	if err != nil {
+		return 0, err
+	}
+	if len(revisions) == 0 {
+		return 0, errors.New("there is no backup")
+	}
+	game, err := gameForImport(user, m.Name)
+	if err != nil {
+		return 0, err
+	}
+	for i, rev := range revisions {
+		if err := uploadRevision(user, game, gameFolder, rev); err != nil {
+			return i, fmt.Errorf("backup %s: %w", rev.name, err)
+		}
+	}
+	log.Printf("%s: %d revisions imported for %s", m.Name, len(revisions), user.Username)
+	return len(revisions), nil
+}
+
+// revisions give the files of each backup, a differential backup is applied on its full backup
+func (m *mapping) revisions() ([]*revision, error) {
+	revisions := make([]*revision, 0)
+	for _, full := range m.Backups {
+		files := make(map[string]source)
+		for name := range full.Files {
+			rel, err := m.backupPath(name)
+			if err != nil {
+				return nil, err
+			}
+			files[rel] = source{backup: full.Name, name: rel}
+		}
+		revisions = append(revisions, &revision{name: full.Name, files: files})
+		for _, diff := range full.Children {
+			diffFiles := make(map[string]source, len(files))
+			for rel, src := range files {
+				diffFiles[rel] = src
+			}
+			for name, file := range diff.Files {
+				rel, err := m.backupPath(name)
+				if err != nil {
+					return nil, err
+				}
+				if file == nil {
+					delete(diffFiles, rel)
+					continue
+				}
+				diffFiles[rel] = source{backup: diff.Name, name: rel}
+			}
+			revisions = append(revisions, &revision{name: diff.Name, files: diffFiles})
+		}
+	}
+	return revisions, nil
+}
+
+// backupPath give the path of a file in the backup, the drive of the original path is replaced by
+// the folder of the drive, like "C:/Users/save.dat" in "drive-0/Users/save.dat"
+func (m *mapping) backupPath(name string) (string, error) {
+	name = filepath.ToSlash(name)
+	bestFolder, bestDrive := "", ""
+	found := false
+	for folder, drive := range m.Drives {
+		if strings.HasPrefix(name, drive) && (!found || len(drive) > len(bestDrive)) {
+			bestFolder, bestDrive, found = folder, drive, true
+		}
+	}
+	if !found {
+		return "", fmt.Errorf("the drive of the file %s is not in the mapping", name)
+	}
+	rel := path.Join(bestFolder, strings.TrimPrefix(name, bestDrive))
+	if strings.HasPrefix(rel, "../") || rel == ".." {
+		return "", fmt.Errorf("the file %s is outside of its drive", name)
+	}
+	return rel, nil
+}
+
+// gameForImport give the game of the user with the same name, or create it
+func gameForImport(user *database.User, name string) (*database.Game, error) {
+	games, err := database.GameInfosByUserId(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, game := range games {
+		if strings.EqualFold(game.Name, name) {
+			if game.Available {
+				return nil, errors.New("the game already exists with a save")
+			}
+			return game, nil
+		}
+	}
+	game, err := database.CreateGame(user.ID, name)
+	if err != nil {
+		return nil, err
+	}
+	if catalogGame, err := catalog.Resolve(name); err == nil {
+		if err := database.SetGameCatalog(game, &catalogGame.ID); err != nil {
+			return nil, err
+		}
+	}
+	return game, nil
+}
+
+// uploadRevision build the archive of the revision and upload it like UploadSave does. The hash of the save is
+// left empty: the client compute it from the save folder with its own algorithm, which cannot be reproduced from the
+// backup, so an empty hash make the client see the save as different and download it
+func uploadRevision(user *database.User, game *database.Game, gameFolder string, rev *revision) error {
+	if _, err := upload.AskForUpload(user.ID, game.ID); err != nil {
+		return err
+	}
+	defer upload.UnlockGame(game.ID)
+	archive, err := os.CreateTemp("", "osc-ludusavi-*.tar.gz")
+	if err != nil {
+		return err
+	}
+	defer func(archive *os.File) {
+		if err := archive.Close(); err != nil {
+			log.Println(err)
+		}
+		if err := os.Remove(archive.Name()); err != nil {
+			log.Println(err)
+		}
+	}(archive)
+	if err := writeArchive(archive, gameFolder, rev); err != nil {
+		return err
+	}
+	if _, err := archive.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	archiveHash, err := upload.FileHash(archive.Name())
+	if err != nil {
+		return err
+	}
+	info, err := archive.Stat()
+	if err != nil {
+		return err
+	}
+	if err := upload.CheckQuota(game, info.Size()); err != nil {
+		return err
+	}
+	if err := upload.UploadToCache(archive, game); err != nil {
+		return err
+	}
+	if err := upload.ValidateAndMove(game, archiveHash); err != nil {
+		return err
+	}
+	revision := &database.Revision{
+		UserId: user.ID,
+		Device: "Ludusavi",
+		Note:   "Imported from the Ludusavi backup " + rev.name,
+	}
+	return database.UpdateGameRevision(game, "", revision)
+}
+
+// writeArchive write the files of the revision in a tar.gz archive
+func writeArchive(w io.Writer, gameFolder string, rev *revision) error {
+	names := make([]string, 0, len(rev.files))
+	for name := range rev.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	zips := make(map[string]*zip.ReadCloser)
+	defer func() {
+		for _, z := range zips {
+			if err := z.Close(); err != nil {
+				log.Println(err)
+			}
+		}
+	}()
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	for _, name := range names {
+		src := rev.files[name]
+		reader, size, err := openSource(gameFolder, src, zips)
+		if err != nil {
+			return err
+		}
+		err = tw.WriteHeader(&tar.Header{
+			Name:     name,
+			Mode:     0644,
+			Size:     size,
+			ModTime:  time.Now(),
+			Typeflag: tar.TypeReg,
+		})
+		if err == nil {
+			_, err = io.Copy(tw, reader)
+		}
+		if closeErr := reader.Close(); closeErr != nil {
+			log.Println(closeErr)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// openSource open a file of a backup. The backup "." is the game folder itself, a backup that
+// ends with .zip is a zip archive and the others are folders
+func openSource(gameFolder string, src source, zips map[string]*zip.ReadCloser) (io.ReadCloser, int64, error) {
+	if strings.HasSuffix(src.backup, ".zip") {
+		z, ok := zips[src.backup]
+		if !ok {
+			var err error
+			z, err = zip.OpenReader(filepath.Join(gameFolder, src.backup))
+			if err != nil {
+				return nil, 0, err
+			}
+			zips[src.backup] = z
+		}
+		for _, f := range z.File {
+			if f.Name == src.name {
+				reader, err := f.Open()
+				if err != nil {
+					return nil, 0, err
+				}
+				return reader, int64(f.UncompressedSize64), nil
+			}
+		}
+		return nil, 0, fmt.Errorf("the file %s is missing from %s", src.name, src.backup)
+	}
+	filePath := filepath.Join(gameFolder, src.backup, filepath.FromSlash(src.name))
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return nil, 0, err
+	}
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, 0, err
+	}
+	return f, info.Size(), nil
+}
@@ -13,5 +13,8 @@ import (
 func main() {
 	fmt.Printf("Open Save Cloud (Server) %s (%s %s)\n", constant.Version, runtime.GOOS, runtime.GOARCH)
 	InitCommon()
+	if RunCommand() {
+		return
+	}
 	server.Serve()
 }
@@ -18,6 +18,9 @@ var icon []byte
 func main() {
 	go func() {
 		InitCommon()
+		if RunCommand() {
+			quit()
+		}
 		server.Serve()
 	}()
 	systray.Run(onReady, onExit)
@@ -2,6 +2,7 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
 	"github.com/go-chi/chi/v5"
 	"io"
 	"log"
@@ -9,15 +10,32 @@ import (
 	"opensavecloudserver/admin"
 	"opensavecloudserver/authentication"
 	"opensavecloudserver/database"
+	"opensavecloudserver/upload"
 	"strconv"
 	"time"
 )
 
+type UpdateRole struct {
+	Id   int    `json:"id"`
+	Role string `json:"role"`
+}
+
 type UpdateUsername struct {
 	Id       int    `json:"id"`
 	Username string `json:"username"`
 }
 
+type UpdateStorageQuota struct {
+	Id           int    `json:"id"`
+	StorageQuota *int64 `json:"storage_quota"`
+}
+
+type StorageUsage struct {
+	UserId       int    `json:"user_id"`
+	Used         int64  `json:"used"`
+	StorageQuota *int64 `json:"storage_quota"`
+}
+
 func AddUser(w http.ResponseWriter, r *http.Request) {
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
@@ -34,8 +52,7 @@ func AddUser(w http.ResponseWriter, r *http.Request) {
 	}
 	err = authentication.Register(userInfo)
 	if err != nil {
-		internalServerError(w, r)
-		log.Println(err)
+		badRequest(err.Error(), w, r)
 		return
 	}
 	user, err := database.UserByUsername(userInfo.Username)
@@ -61,6 +78,16 @@ func RemoveUser(w http.ResponseWriter, r *http.Request) {
 		log.Println(err)
 		return
 	}
+	allowed, err := canManageUser(r, user)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	if !allowed {
+		forbidden(w, r)
+		return
+	}
 	err = admin.RemoveUser(user)
 	if err != nil {
 		internalServerError(w, r)
@@ -70,13 +97,24 @@ func RemoveUser(w http.ResponseWriter, r *http.Request) {
 	ok(user, w, r)
 }
 
+// AllUsers list the users, the list can be searched, filtered, sorted and paginated, see userFilterFromQuery
 func AllUsers(w http.ResponseWriter, r *http.Request) {
-	users, err := database.AllUsers()
+	filter, err := userFilterFromQuery(r.URL.Query())
+	if err != nil {
+		badRequest(err.Error(), w, r)
+		return
+	}
+	users, total, next, err := database.Users(filter)
 	if err != nil {
 		internalServerError(w, r)
 		log.Println(err)
 		return
 	}
+	if err := setPageHeaders(w, total, next); err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
 	ok(users, w, r)
 }
 
@@ -111,12 +149,27 @@ func SetAdmin(w http.ResponseWriter, r *http.Request) {
 		log.Println(err)
 		return
 	}
-	err = admin.SetAdmin(user)
+	allowed, err := canManageUser(r, user)
 	if err != nil {
-		notFound(err.Error(), w, r)
+		internalServerError(w, r)
 		log.Println(err)
 		return
 	}
+	if !allowed {
+		forbidden(w, r)
+		return
+	}
+	actor, err := roleFromContext(r)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	err = admin.SetAdmin(actor, user)
+	if err != nil {
+		roleError(err, w, r)
+		return
+	}
 	ok(user, w, r)
 }
 
@@ -134,6 +187,16 @@ func SetNotAdmin(w http.ResponseWriter, r *http.Request) {
 		log.Println(err)
 		return
 	}
+	allowed, err := canManageUser(r, user)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	if !allowed {
+		forbidden(w, r)
+		return
+	}
 	err = admin.RemoveAdminRole(user)
 	if err != nil {
 		notFound(err.Error(), w, r)
@@ -143,6 +206,351 @@ func SetNotAdmin(w http.ResponseWriter, r *http.Request) {
 	ok(user, w, r)
 }
 
+func UserSessions(w http.ResponseWriter, r *http.Request) {
+	queryId := chi.URLParam(r, "id")
+	id, err := strconv.Atoi(queryId)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	user, err := database.UserById(id)
+	if err != nil {
+		notFound(err.Error(), w, r)
+		log.Println(err)
+		return
+	}
+	allowed, err := canManageUser(r, user)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	if !allowed {
+		forbidden(w, r)
+		return
+	}
+	sessions, err := authentication.Sessions(user.ID, "")
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	ok(sessions, w, r)
+}
+
+func RemoveSession(w http.ResponseWriter, r *http.Request) {
+	session, err := database.SessionById(chi.URLParam(r, "id"))
+	if err != nil {
+		notFound(err.Error(), w, r)
+		log.Println(err)
+		return
+	}
+	user, err := database.UserById(session.UserId)
+	if err != nil {
+		notFound(err.Error(), w, r)
+		log.Println(err)
+		return
+	}
+	allowed, err := canManageUser(r, user)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	if !allowed {
+		forbidden(w, r)
+		return
+	}
+	err = authentication.Logout(session.ID)
+	if err != nil {
+		notFound(err.Error(), w, r)
+		log.Println(err)
+		return
+	}
+	payload := &successMessage{
+		Message:   "Session revoked",
+		Timestamp: time.Now(),
+		Status:    200,
+	}
+	ok(payload, w, r)
+}
+
+func ResetTotp(w http.ResponseWriter, r *http.Request) {
+	queryId := chi.URLParam(r, "id")
+	id, err := strconv.Atoi(queryId)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	user, err := database.UserById(id)
+	if err != nil {
+		notFound(err.Error(), w, r)
+		log.Println(err)
+		return
+	}
+	allowed, err := canManageUser(r, user)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	if !allowed {
+		forbidden(w, r)
+		return
+	}
+	err = admin.ResetTotp(user)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	ok(user, w, r)
+}
+
+func Lockouts(w http.ResponseWriter, r *http.Request) {
+	ok(authentication.Lockouts(), w, r)
+}
+
+func ClearUserLockout(w http.ResponseWriter, r *http.Request) {
+	clearLockout(authentication.AttemptKindUser, chi.URLParam(r, "username"), w, r)
+}
+
+func ClearIpLockout(w http.ResponseWriter, r *http.Request) {
+	clearLockout(authentication.AttemptKindIp, chi.URLParam(r, "ip"), w, r)
+}
+
+func clearLockout(kind, value string, w http.ResponseWriter, r *http.Request) {
+	if !authentication.ClearLockout(kind, value) {
+		notFound("There is no failed attempt for this "+kind, w, r)
+		return
+	}
+	payload := &successMessage{
+		Message:   "Lockout cleared",
+		Timestamp: time.Now(),
+		Status:    200,
+	}
+	ok(payload, w, r)
+}
+
+func CreateInvitation(w http.ResponseWriter, r *http.Request) {
+	userId, err := userIdFromContext(r.Context())
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	invitationInfo := new(authentication.NewInvitation)
+	err = json.Unmarshal(body, invitationInfo)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	invitation, err := authentication.CreateInvitation(userId, invitationInfo)
+	if err != nil {
+		badRequest(err.Error(), w, r)
+		return
+	}
+	ok(invitation, w, r)
+}
+
+func Invitations(w http.ResponseWriter, r *http.Request) {
+	invitations, err := authentication.Invitations()
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	ok(invitations, w, r)
+}
+
+func RevokeInvitation(w http.ResponseWriter, r *http.Request) {
+	queryId := chi.URLParam(r, "id")
+	id, err := strconv.Atoi(queryId)
+	if err != nil {
+		badRequest("Invitation ID missing or not an int", w, r)
+		log.Println(err)
+		return
+	}
+	err = authentication.RevokeInvitation(id)
+	if err != nil {
+		notFound(err.Error(), w, r)
+		log.Println(err)
+		return
+	}
+	payload := &successMessage{
+		Message:   "Invitation revoked",
+		Timestamp: time.Now(),
+		Status:    200,
+	}
+	ok(payload, w, r)
+}
+
+// CreatePasswordReset create a single-use link token to let the user set a new password
+func CreatePasswordReset(w http.ResponseWriter, r *http.Request) {
+	adminId, err := userIdFromContext(r.Context())
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	queryId := chi.URLParam(r, "id")
+	id, err := strconv.Atoi(queryId)
+	if err != nil {
+		badRequest("User ID missing or not an int", w, r)
+		log.Println(err)
+		return
+	}
+	user, err := database.UserById(id)
+	if err != nil {
+		notFound(err.Error(), w, r)
+		log.Println(err)
+		return
+	}
+	allowed, err := canManageUser(r, user)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	if !allowed {
+		forbidden(w, r)
+		return
+	}
+	reset, err := authentication.CreatePasswordReset(adminId, id, clientInfo("", r).Ip)
+	if err != nil {
+		notFound(err.Error(), w, r)
+		log.Println(err)
+		return
+	}
+	ok(reset, w, r)
+}
+
+func AuditEvents(w http.ResponseWriter, r *http.Request) {
+	limit := 100
+	if queryLimit := r.URL.Query().Get("limit"); len(queryLimit) > 0 {
+		l, err := strconv.Atoi(queryLimit)
+		if err != nil || l <= 0 {
+			badRequest("The limit must be a positive int", w, r)
+			return
+		}
+		limit = l
+	}
+	events, err := database.AuditEvents(limit)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	ok(events, w, r)
+}
+
+func Roles(w http.ResponseWriter, r *http.Request) {
+	roles, err := database.AllRoles()
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	ok(roles, w, r)
+}
+
+// SaveRole create a role or update the description and the permissions of an existing one
+func SaveRole(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	role := new(database.Role)
+	err = json.Unmarshal(body, role)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	actor, err := roleFromContext(r)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	role, err = admin.SaveRole(actor, role)
+	if err != nil {
+		roleError(err, w, r)
+		return
+	}
+	ok(role, w, r)
+}
+
+func RemoveRole(w http.ResponseWriter, r *http.Request) {
+	err := admin.RemoveRole(chi.URLParam(r, "name"))
+	if err != nil {
+		badRequest(err.Error(), w, r)
+		return
+	}
+	payload := &successMessage{
+		Message:   "Role removed",
+		Timestamp: time.Now(),
+		Status:    200,
+	}
+	ok(payload, w, r)
+}
+
+// SetUserRole give a role to a user
+func SetUserRole(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	update := new(UpdateRole)
+	err = json.Unmarshal(body, update)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	user, err := database.UserById(update.Id)
+	if err != nil {
+		notFound(err.Error(), w, r)
+		log.Println(err)
+		return
+	}
+	allowed, err := canManageUser(r, user)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	if !allowed {
+		forbidden(w, r)
+		return
+	}
+	actor, err := roleFromContext(r)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	err = admin.SetRole(actor, user, update.Role)
+	if err != nil {
+		roleError(err, w, r)
+		return
+	}
+	ok(user, w, r)
+}
+
 func ChangeUserPassword(w http.ResponseWriter, r *http.Request) {
 	queryId := chi.URLParam(r, "id")
 	userId, err := strconv.Atoi(queryId)
@@ -151,6 +559,22 @@ func ChangeUserPassword(w http.ResponseWriter, r *http.Request) {
 		log.Println(err)
 		return
 	}
+	user, err := database.UserById(userId)
+	if err != nil {
+		notFound(err.Error(), w, r)
+		log.Println(err)
+		return
+	}
+	allowed, err := canManageUser(r, user)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	if !allowed {
+		forbidden(w, r)
+		return
+	}
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
 		internalServerError(w, r)
@@ -168,10 +592,9 @@ func ChangeUserPassword(w http.ResponseWriter, r *http.Request) {
 		badRequest("password are not the same", w, r)
 		return
 	}
-	err = database.ChangePassword(userId, []byte(newPassword.Password))
+	err = authentication.ChangePassword(userId, newPassword.Password, "")
 	if err != nil {
-		internalServerError(w, r)
-		log.Println(err)
+		badRequest(err.Error(), w, r)
 		return
 	}
 	payload := &successMessage{
@@ -196,6 +619,22 @@ func ChangeUsername(w http.ResponseWriter, r *http.Request) {
 		log.Println(err)
 		return
 	}
+	user, err := database.UserById(newUserInfo.Id)
+	if err != nil {
+		notFound(err.Error(), w, r)
+		log.Println(err)
+		return
+	}
+	allowed, err := canManageUser(r, user)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	if !allowed {
+		forbidden(w, r)
+		return
+	}
 	if len(newUserInfo.Username) < 3 {
 		badRequest("username need at least 3 characters", w, r)
 		return
@@ -218,3 +657,103 @@ func ChangeUsername(w http.ResponseWriter, r *http.Request) {
 	}
 	ok(payload, w, r)
 }
+
+// UserStorage give the size of the game saves of the user and their storage quota
+func UserStorage(w http.ResponseWriter, r *http.Request) {
+	queryId := chi.URLParam(r, "id")
+	id, err := strconv.Atoi(queryId)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	user, err := database.UserById(id)
+	if err != nil {
+		notFound(err.Error(), w, r)
+		log.Println(err)
+		return
+	}
+	used, err := upload.UserStorageUsage(user.ID)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	payload := &StorageUsage{
+		UserId:       user.ID,
+		Used:         used,
+		StorageQuota: user.StorageQuota,
+	}
+	ok(payload, w, r)
+}
+
+// SetStorageQuota change the storage quota of a user, without quota the storage is unlimited
+func SetStorageQuota(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	update := new(UpdateStorageQuota)
+	err = json.Unmarshal(body, update)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	user, err := database.UserById(update.Id)
+	if err != nil {
+		notFound(err.Error(), w, r)
+		log.Println(err)
+		return
+	}
+	allowed, err := canManageUser(r, user)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	if !allowed {
+		forbidden(w, r)
+		return
+	}
+	err = admin.SetStorageQuota(user, update.StorageQuota)
+	if err != nil {
+		badRequest(err.Error(), w, r)
+		return
+	}
+	ok(user, w, r)
+}
+
+// canManageUser check that the role of the administrator give all the permissions of the role of the user,
+// so an administrator cannot take over an account that has more permissions than them
+func canManageUser(r *http.Request, user *database.User) (bool, error) {
+	role, err := roleFromContext(r)
+	if err != nil {
+		return false, err
+	}
+	userRole, err := database.RoleByName(user.Role)
+	if err != nil {
+		return false, err
+	}
+	return role.Includes(userRole), nil
+}
+
+// roleFromContext give the role of the administrator that sent the request
+func roleFromContext(r *http.Request) (*database.Role, error) {
+	role, found := r.Context().Value(RoleKey).(*database.Role)
+	if !found {
+		return nil, errors.New("role not found in context")
+	}
+	return role, nil
+}
+
+// roleError respond to a refused role change, a role with more permissions than the administrator is forbidden
+func roleError(err error, w http.ResponseWriter, r *http.Request) {
+	if errors.Is(err, admin.ErrRoleNotAllowed) {
+		forbidden(w, r)
+		return
+	}
+	badRequest(err.Error(), w, r)
+}
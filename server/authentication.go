@@ -2,20 +2,62 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi/v5"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"opensavecloudserver/authentication"
+	"strconv"
 	"time"
 )
 
 type Credential struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
+	Device   string `json:"device"`
 }
 
 type TokenValidation struct {
-	Valid bool `json:"valid"`
+	Valid     bool       `json:"valid"`
+	Expire    *time.Time `json:"expire"`
+	ExpiresIn int64      `json:"expires_in"`
+}
+
+type TotpCredential struct {
+	Challenge string `json:"challenge"`
+	Code      string `json:"code"`
+	Device    string `json:"device"`
+}
+
+type TotpCode struct {
+	Code string `json:"code"`
+}
+
+type OidcAuthorizationRequest struct {
+	Device string `json:"device"`
+}
+
+type OidcPoll struct {
+	State   string `json:"state"`
+	PollKey string `json:"poll_key"`
+}
+
+type DeviceCodeRequest struct {
+	Device string `json:"device"`
+}
+
+type DeviceTokenRequest struct {
+	DeviceCode string `json:"device_code"`
+}
+
+type DeviceApproval struct {
+	UserCode string `json:"user_code"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
 }
 
 func Login(w http.ResponseWriter, r *http.Request) {
@@ -32,9 +74,36 @@ func Login(w http.ResponseWriter, r *http.Request) {
 		log.Println(err)
 		return
 	}
-	token, err := authentication.Connect(credential.Username, credential.Password)
+	token, challenge, err := authentication.Connect(credential.Username, credential.Password, clientInfo(credential.Device, r))
 	if err != nil {
-		unauthorized(w, r)
+		loginError(err, w, r)
+		return
+	}
+	if challenge != nil {
+		ok(challenge, w, r)
+		return
+	}
+	ok(token, w, r)
+}
+
+// LoginTotp is the second step of the login for the users with the two-factor authentication
+func LoginTotp(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	totpCredential := new(TotpCredential)
+	err = json.Unmarshal(body, totpCredential)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	token, err := authentication.ConnectTotp(totpCredential.Challenge, totpCredential.Code, clientInfo(totpCredential.Device, r))
+	if err != nil {
+		loginError(err, w, r)
 		return
 	}
 	ok(token, w, r)
@@ -54,7 +123,7 @@ func Register(w http.ResponseWriter, r *http.Request) {
 		log.Println(err)
 		return
 	}
-	err = authentication.Register(registration)
+	err = authentication.RegisterWithInvitation(registration)
 	if err != nil {
 		badRequest(err.Error(), w, r)
 		return
@@ -81,7 +150,7 @@ func CheckToken(w http.ResponseWriter, r *http.Request) {
 		log.Println(err)
 		return
 	}
-	_, err = authentication.ParseToken(credential.Token)
+	info, err := authentication.Authenticate(credential.Token)
 	if err != nil {
 		payload := TokenValidation{
 			Valid: false,
@@ -92,5 +161,580 @@ func CheckToken(w http.ResponseWriter, r *http.Request) {
 	payload := TokenValidation{
 		Valid: true,
 	}
+	if !info.Expire.IsZero() {
+		payload.Expire = &info.Expire
+		payload.ExpiresIn = int64(time.Until(info.Expire).Seconds())
+	}
+	ok(payload, w, r)
+}
+
+// Refresh give a new access token in exchange of a refresh token
+func Refresh(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	refreshRequest := new(RefreshRequest)
+	err = json.Unmarshal(body, refreshRequest)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	token, err := authentication.Refresh(refreshRequest.RefreshToken, clientInfo("", r))
+	if err != nil {
+		unauthorized(w, r)
+		return
+	}
+	ok(token, w, r)
+}
+
+// Logout close the session of the token used for this request
+func Logout(w http.ResponseWriter, r *http.Request) {
+	sessionId, err := sessionIdFromContext(r.Context())
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	err = authentication.Logout(sessionId)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	payload := &successMessage{
+		Message:   "You are now logged out",
+		Timestamp: time.Now(),
+		Status:    200,
+	}
+	ok(payload, w, r)
+}
+
+// Sessions list the active sessions of the user
+func Sessions(w http.ResponseWriter, r *http.Request) {
+	userId, err := userIdFromContext(r.Context())
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	sessionId, err := sessionIdFromContext(r.Context())
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	sessions, err := authentication.Sessions(userId, sessionId)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	ok(sessions, w, r)
+}
+
+// RevokeSession close one of the sessions of the user
+func RevokeSession(w http.ResponseWriter, r *http.Request) {
+	userId, err := userIdFromContext(r.Context())
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	sessionId := chi.URLParam(r, "id")
+	err = authentication.RevokeSession(userId, sessionId)
+	if err != nil {
+		notFound(err.Error(), w, r)
+		log.Println(err)
+		return
+	}
+	payload := &successMessage{
+		Message:   "Session revoked",
+		Timestamp: time.Now(),
+		Status:    200,
+	}
+	ok(payload, w, r)
+}
+
+// DeviceAuthorize start the authorization of a device, the user code must be approved from a logged in session
+func DeviceAuthorize(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	codeRequest := new(DeviceCodeRequest)
+	if len(body) > 0 {
+		err = json.Unmarshal(body, codeRequest)
+		if err != nil {
+			internalServerError(w, r)
+			log.Println(err)
+			return
+		}
+	}
+	authorization, err := authentication.DeviceAuthorize(clientInfo(codeRequest.Device, r))
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	ok(authorization, w, r)
+}
+
+// DeviceToken is polled by the device until the user approve or deny the request
+func DeviceToken(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	tokenRequest := new(DeviceTokenRequest)
+	err = json.Unmarshal(body, tokenRequest)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	token, err := authentication.DeviceToken(tokenRequest.DeviceCode)
+	if err != nil {
+		switch err {
+		case authentication.ErrAuthorizationPending, authentication.ErrSlowDown, authentication.ErrAccessDenied, authentication.ErrExpiredToken:
+			badRequest(err.Error(), w, r)
+		default:
+			internalServerError(w, r)
+			log.Println(err)
+		}
+		return
+	}
+	ok(token, w, r)
+}
+
+// PendingDevice show the device that requested a user code, before the approval
+func PendingDevice(w http.ResponseWriter, r *http.Request) {
+	request, err := authentication.PendingDevice(chi.URLParam(r, "code"), clientInfo("", r).Ip)
+	if err != nil {
+		deviceError(err, w, r)
+		return
+	}
+	ok(request, w, r)
+}
+
+// ApproveDevice allow the device of the user code to open a session for the user
+func ApproveDevice(w http.ResponseWriter, r *http.Request) {
+	userId, err := userIdFromContext(r.Context())
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	approval, err := deviceApprovalFromBody(r)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	err = authentication.ApproveDevice(userId, approval.UserCode, clientInfo("", r).Ip)
+	if err != nil {
+		deviceError(err, w, r)
+		return
+	}
+	payload := &successMessage{
+		Message:   "Device approved",
+		Timestamp: time.Now(),
+		Status:    200,
+	}
+	ok(payload, w, r)
+}
+
+// DenyDevice refuse the authorization of the device of the user code
+func DenyDevice(w http.ResponseWriter, r *http.Request) {
+	userId, err := userIdFromContext(r.Context())
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	approval, err := deviceApprovalFromBody(r)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	err = authentication.DenyDevice(userId, approval.UserCode, clientInfo("", r).Ip)
+	if err != nil {
+		deviceError(err, w, r)
+		return
+	}
+	payload := &successMessage{
+		Message:   "Device denied",
+		Timestamp: time.Now(),
+		Status:    200,
+	}
+	ok(payload, w, r)
+}
+
+func deviceApprovalFromBody(r *http.Request) (*DeviceApproval, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	approval := new(DeviceApproval)
+	err = json.Unmarshal(body, approval)
+	if err != nil {
+		return nil, err
+	}
+	return approval, nil
+}
+
+// deviceError respond to a wrong user code, the client is told when it can retry if it guessed too many codes
+func deviceError(err error, w http.ResponseWriter, r *http.Request) {
+	var attemptsErr *authentication.AttemptsError
+	if errors.As(err, &attemptsErr) {
+		tooManyRequests(attemptsErr.Until, w, r)
+		return
+	}
+	notFound(err.Error(), w, r)
+}
+
+// loginError respond to a failed login, the client is told when it can retry if it is locked out
+func loginError(err error, w http.ResponseWriter, r *http.Request) {
+	var attemptsErr *authentication.AttemptsError
+	if errors.As(err, &attemptsErr) {
+		tooManyRequests(attemptsErr.Until, w, r)
+		return
+	}
+	unauthorized(w, r)
+}
+
+// clientInfo describe the client of the request, the User-Agent is used when the device name is not given
+func clientInfo(device string, r *http.Request) authentication.ClientInfo {
+	if len(device) == 0 {
+		device = r.UserAgent()
+	}
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	return authentication.ClientInfo{
+		Device: device,
+		Ip:     ip,
+	}
+}
+
+// CreatePersonalAccessToken create a token restricted to some scopes, for the scripts and automation
+func CreatePersonalAccessToken(w http.ResponseWriter, r *http.Request) {
+	userId, err := userIdFromContext(r.Context())
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	tokenInfo := new(authentication.NewPersonalAccessToken)
+	err = json.Unmarshal(body, tokenInfo)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	token, err := authentication.CreatePersonalAccessToken(userId, tokenInfo)
+	if err != nil {
+		badRequest(err.Error(), w, r)
+		return
+	}
+	ok(token, w, r)
+}
+
+// PersonalAccessTokens list the personal access tokens of the user
+func PersonalAccessTokens(w http.ResponseWriter, r *http.Request) {
+	userId, err := userIdFromContext(r.Context())
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	tokens, err := authentication.PersonalAccessTokens(userId)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	ok(tokens, w, r)
+}
+
+// RevokePersonalAccessToken remove a personal access token of the user
+func RevokePersonalAccessToken(w http.ResponseWriter, r *http.Request) {
+	userId, err := userIdFromContext(r.Context())
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	queryId := chi.URLParam(r, "id")
+	id, err := strconv.Atoi(queryId)
+	if err != nil {
+		badRequest("Token ID missing or not an int", w, r)
+		log.Println(err)
+		return
+	}
+	err = authentication.RevokePersonalAccessToken(userId, id)
+	if err != nil {
+		notFound(err.Error(), w, r)
+		log.Println(err)
+		return
+	}
+	payload := &successMessage{
+		Message:   "Token revoked",
+		Timestamp: time.Now(),
+		Status:    200,
+	}
+	ok(payload, w, r)
+}
+
+// EnrollTotp generate the TOTP secret of the user, it must be confirmed with ConfirmTotp
+func EnrollTotp(w http.ResponseWriter, r *http.Request) {
+	userId, err := userIdFromContext(r.Context())
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	enrollment, err := authentication.EnrollTotp(userId)
+	if err != nil {
+		badRequest(err.Error(), w, r)
+		return
+	}
+	ok(enrollment, w, r)
+}
+
+// ConfirmTotp enable the two-factor authentication and give the recovery codes
+func ConfirmTotp(w http.ResponseWriter, r *http.Request) {
+	userId, err := userIdFromContext(r.Context())
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	code, err := totpCodeFromBody(r)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	codes, err := authentication.ConfirmTotp(userId, code.Code)
+	if err != nil {
+		badRequest(err.Error(), w, r)
+		return
+	}
+	ok(codes, w, r)
+}
+
+// DisableTotp disable the two-factor authentication of the user
+func DisableTotp(w http.ResponseWriter, r *http.Request) {
+	userId, err := userIdFromContext(r.Context())
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	code, err := totpCodeFromBody(r)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	err = authentication.DisableTotp(userId, code.Code)
+	if err != nil {
+		badRequest(err.Error(), w, r)
+		return
+	}
+	payload := &successMessage{
+		Message:   "Two-factor authentication disabled",
+		Timestamp: time.Now(),
+		Status:    200,
+	}
+	ok(payload, w, r)
+}
+
+// RecoveryCodes replace the recovery codes of the user
+func RecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	userId, err := userIdFromContext(r.Context())
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	code, err := totpCodeFromBody(r)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	codes, err := authentication.RegenerateRecoveryCodes(userId, code.Code)
+	if err != nil {
+		badRequest(err.Error(), w, r)
+		return
+	}
+	ok(codes, w, r)
+}
+
+func totpCodeFromBody(r *http.Request) (*TotpCode, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	code := new(TotpCode)
+	err = json.Unmarshal(body, code)
+	if err != nil {
+		return nil, err
+	}
+	return code, nil
+}
+
+// OidcAuthorize start a single sign-on, the client must open the authorization url in a browser
+func OidcAuthorize(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	authorizationRequest := new(OidcAuthorizationRequest)
+	if len(body) > 0 {
+		err = json.Unmarshal(body, authorizationRequest)
+		if err != nil {
+			internalServerError(w, r)
+			log.Println(err)
+			return
+		}
+	}
+	authorization, err := authentication.OidcAuthorize(clientInfo(authorizationRequest.Device, r).Device)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	ok(authorization, w, r)
+}
+
+// OidcCallback is the redirection of the provider after the user authorized the server, the user is asked
+// for the code shown by the application before the login is completed
+func OidcCallback(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if e := query.Get("error"); len(e) > 0 {
+		renderOidcPage(http.StatusBadRequest, &oidcPageData{Message: "The login was refused by the provider: " + e + " " + query.Get("error_description")}, w)
+		return
+	}
+	state := query.Get("state")
+	err := authentication.OidcCallback(state, query.Get("code"), clientInfo("", r))
+	if err != nil {
+		renderOidcPage(oidcErrorStatus(err), &oidcPageData{Message: "The login failed, start it again from the application."}, w)
+		log.Println(err)
+		return
+	}
+	renderOidcPage(http.StatusOK, &oidcPageData{State: state}, w)
+}
+
+// OidcConfirm check the code typed by the user in the browser and complete the login
+func OidcConfirm(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, 1<<16)
+	if err := r.ParseForm(); err != nil {
+		renderOidcPage(http.StatusBadRequest, &oidcPageData{Message: "The form cannot be read."}, w)
+		return
+	}
+	state := r.PostForm.Get("state")
+	err := authentication.OidcConfirm(state, r.PostForm.Get("user_code"), clientInfo("", r))
+	if err != nil {
+		var attemptsErr *authentication.AttemptsError
+		if errors.Is(err, authentication.ErrWrongUserCode) {
+			renderOidcPage(http.StatusUnauthorized, &oidcPageData{State: state, Error: err.Error()}, w)
+			return
+		}
+		if errors.As(err, &attemptsErr) {
+			renderOidcPage(http.StatusTooManyRequests, &oidcPageData{Message: err.Error()}, w)
+			return
+		}
+		renderOidcPage(http.StatusUnauthorized, &oidcPageData{Message: "The login failed, start it again from the application."}, w)
+		log.Println(err)
+		return
+	}
+	renderOidcPage(http.StatusOK, &oidcPageData{Message: "You are now logged in, you can go back to the application."}, w)
+}
+
+func oidcErrorStatus(err error) int {
+	var attemptsErr *authentication.AttemptsError
+	if errors.As(err, &attemptsErr) {
+		return http.StatusTooManyRequests
+	}
+	return http.StatusUnauthorized
+}
+
+// OidcToken give the token once the user completed the single sign-on, or a TOTP challenge when the user
+// has the two-factor authentication
+func OidcToken(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	poll := new(OidcPoll)
+	err = json.Unmarshal(body, poll)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	token, challenge, err := authentication.OidcToken(poll.State, poll.PollKey)
+	if err != nil {
+		if err == authentication.ErrAuthorizationPending {
+			badRequest(err.Error(), w, r)
+			return
+		}
+		loginError(err, w, r)
+		return
+	}
+	if challenge != nil {
+		ok(challenge, w, r)
+		return
+	}
+	ok(token, w, r)
+}
+
+// ResetPassword set a new password with the token of a reset link given by an administrator
+func ResetPassword(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	reset := new(authentication.PasswordReset)
+	err = json.Unmarshal(body, reset)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	err = authentication.ResetPassword(reset, clientInfo("", r).Ip)
+	if err != nil {
+		badRequest(err.Error(), w, r)
+		return
+	}
+	payload := &successMessage{
+		Message:   "Password changed",
+		Timestamp: time.Now(),
+		Status:    200,
+	}
 	ok(payload, w, r)
 }
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"io"
+	"log"
+	"net/http"
+	"opensavecloudserver/catalog"
+	"opensavecloudserver/database"
+	"strconv"
+	"time"
+)
+
+// maxLudusaviManifestSize is the biggest Ludusavi manifest that can be imported, the manifest is read in memory
+const maxLudusaviManifestSize = 64 << 20
+
+// SearchCatalog find the games of the catalog by their title
+func SearchCatalog(w http.ResponseWriter, r *http.Request) {
+	games, err := catalog.Search(r.URL.Query().Get("q"), 50)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	ok(games, w, r)
+}
+
+func CatalogGame(w http.ResponseWriter, r *http.Request) {
+	queryId := chi.URLParam(r, "id")
+	id, err := strconv.Atoi(queryId)
+	if err != nil {
+		badRequest("Catalog ID missing or not an int", w, r)
+		log.Println(err)
+		return
+	}
+	game, err := database.CatalogGameById(id)
+	if err != nil {
+		notFound(err.Error(), w, r)
+		log.Println(err)
+		return
+	}
+	ok(game, w, r)
+}
+
+// CatalogSavePaths give the save path rules of a catalog game for the OS of the query parameter os
+func CatalogSavePaths(w http.ResponseWriter, r *http.Request) {
+	queryId := chi.URLParam(r, "id")
+	id, err := strconv.Atoi(queryId)
+	if err != nil {
+		badRequest("Catalog ID missing or not an int", w, r)
+		log.Println(err)
+		return
+	}
+	game, err := database.CatalogGameById(id)
+	if err != nil {
+		notFound(err.Error(), w, r)
+		log.Println(err)
+		return
+	}
+	paths, err := catalog.PathsForOs(game, r.URL.Query().Get("os"))
+	if err != nil {
+		badRequest(err.Error(), w, r)
+		return
+	}
+	ok(paths, w, r)
+}
+
+// ImportLudusavi import a Ludusavi manifest given in the body of the request
+func ImportLudusavi(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxLudusaviManifestSize)
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		badRequest(fmt.Sprintf("The manifest cannot be read or is bigger than %d MB", maxLudusaviManifestSize>>20), w, r)
+		return
+	}
+	result, err := catalog.ImportLudusavi(body)
+	if err != nil {
+		badRequest(err.Error(), w, r)
+		return
+	}
+	ok(result, w, r)
+}
+
+// ImportCatalog import a YAML or JSON catalog file given in the body of the request
+func ImportCatalog(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	result, err := catalog.Import(body)
+	if err != nil {
+		badRequest(err.Error(), w, r)
+		return
+	}
+	ok(result, w, r)
+}
+
+// SaveCatalogGame create or update a single game of the catalog
+func SaveCatalogGame(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	entry := new(catalog.Entry)
+	err = json.Unmarshal(body, entry)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	game, _, err := catalog.Save(entry)
+	if err != nil {
+		badRequest(err.Error(), w, r)
+		return
+	}
+	ok(game, w, r)
+}
+
+func RemoveCatalogGame(w http.ResponseWriter, r *http.Request) {
+	queryId := chi.URLParam(r, "id")
+	id, err := strconv.Atoi(queryId)
+	if err != nil {
+		badRequest("Catalog ID missing or not an int", w, r)
+		log.Println(err)
+		return
+	}
+	game, err := database.CatalogGameById(id)
+	if err != nil {
+		notFound(err.Error(), w, r)
+		log.Println(err)
+		return
+	}
+	err = database.RemoveCatalogGame(game)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	payload := &successMessage{
+		Message:   "Catalog game removed",
+		Timestamp: time.Now(),
+		Status:    200,
+	}
+	ok(payload, w, r)
+}
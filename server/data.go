@@ -2,16 +2,19 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/go-chi/chi/v5"
+	"image"
 	"io"
 	"log"
 	"mime/multipart"
 	"net/http"
-	"opensavecloudserver/config"
+	"opensavecloudserver/authentication"
+	"opensavecloudserver/catalog"
 	"opensavecloudserver/database"
 	"opensavecloudserver/upload"
 	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -19,7 +22,9 @@ import (
 )
 
 type NewGameInfo struct {
-	Name string `json:"name"`
+	Name      string `json:"name"`
+	GroupId   int    `json:"group_id"`
+	CatalogId int    `json:"catalog_id"`
 }
 
 type UploadGameInfo struct {
@@ -30,12 +35,57 @@ type LockError struct {
 	Message string `json:"message"`
 }
 
+type ShareGameInfo struct {
+	GameId   int    `json:"game_id"`
+	Username string `json:"username"`
+	Level    string `json:"level"`
+}
+
+// RevisionInfo update the information of a revision, the fields that are not given are not changed
+type RevisionInfo struct {
+	GameId   int       `json:"game_id"`
+	Revision int       `json:"rev"`
+	Note     *string   `json:"note"`
+	Labels   *[]string `json:"labels"`
+	Device   *string   `json:"device"`
+	Pinned   *bool     `json:"pinned"`
+}
+
+// UpdateGameInfo update the metadata of a game, the fields that are not given are not changed and a catalog ID
+// of 0 unlink the game from the catalog
+type UpdateGameInfo struct {
+	GameId    int     `json:"game_id"`
+	Name      *string `json:"name"`
+	CatalogId *int    `json:"catalog_id"`
+	Notes     *string `json:"notes"`
+}
+
+// MoveSaveInfo move the save of a game to another game, with merge the source game is removed after the move
+type MoveSaveInfo struct {
+	From  int  `json:"from"`
+	To    int  `json:"to"`
+	Merge bool `json:"merge"`
+}
+
+// SavePathsInfo replace the save path templates of a game for an OS. With an empty list, the templates of
+// the catalog game are used again
+type SavePathsInfo struct {
+	GameId int      `json:"game_id"`
+	Os     string   `json:"os"`
+	Paths  []string `json:"paths"`
+}
+
+// ShareLinkPassword is the password of a share link given in the body of the download
+type ShareLinkPassword struct {
+	Password string `json:"password"`
+}
+
 type NewPassword struct {
 	Password       string `json:"password"`
 	VerifyPassword string `json:"verify_password"`
 }
 
-// CreateGame create a game entry to the database
+// CreateGame create a game entry to the database, the game is linked to the catalog game of the same name
 func CreateGame(w http.ResponseWriter, r *http.Request) {
 	userId, err := userIdFromContext(r.Context())
 	if err != nil {
@@ -56,12 +106,44 @@ func CreateGame(w http.ResponseWriter, r *http.Request) {
 		log.Println(err)
 		return
 	}
-	game, err := database.CreateGame(userId, gameInfo.Name)
+	if gameInfo.CatalogId != 0 {
+		if _, err := database.CatalogGameById(gameInfo.CatalogId); err != nil {
+			notFound("This catalog game does not exist", w, r)
+			return
+		}
+	} else if catalogGame, err := catalog.Resolve(gameInfo.Name); err == nil {
+		// The name is resolved against the catalog, so the client can find the save paths of the game
+		gameInfo.CatalogId = catalogGame.ID
+	}
+	var game *database.Game
+	if gameInfo.GroupId != 0 {
+		var member *database.GroupMember
+		member, err = database.GroupMemberById(gameInfo.GroupId, userId)
+		if err != nil {
+			notFound("This group does not exist", w, r)
+			return
+		}
+		if member.Role == database.GroupRoleViewer {
+			forbidden(w, r)
+			return
+		}
+		game, err = database.CreateGroupGame(gameInfo.GroupId, gameInfo.Name)
+	} else {
+		game, err = database.CreateGame(userId, gameInfo.Name)
+	}
 	if err != nil {
 		internalServerError(w, r)
 		log.Println(err)
 		return
 	}
+	if gameInfo.CatalogId != 0 {
+		err = database.SetGameCatalog(game, &gameInfo.CatalogId)
+		if err != nil {
+			internalServerError(w, r)
+			log.Println(err)
+			return
+		}
+	}
 	ok(game, w, r)
 }
 
@@ -80,16 +162,35 @@ func GameInfoByID(w http.ResponseWriter, r *http.Request) {
 		log.Println(err)
 		return
 	}
-	game, err := database.GameInfoById(userId, id)
+	game, err := database.AccessibleGameById(userId, id)
 	if err != nil {
 		notFound(err.Error(), w, r)
 		log.Println(err)
 		return
 	}
+	game.Revisions, err = database.RevisionsByGameId(game.ID)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	err = setThumbnailUrls([]*database.Game{game})
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	game.SavePaths, err = savePathTemplates(game)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
 	ok(game, w, r)
 }
 
-// AllGamesInformation all game saves information for a user
+// AllGamesInformation all game saves information for a user, with the games shared with them and the games of their groups.
+// The list can be searched, filtered, sorted and paginated, see gameFilterFromQuery
 func AllGamesInformation(w http.ResponseWriter, r *http.Request) {
 	userId, err := userIdFromContext(r.Context())
 	if err != nil {
@@ -97,16 +198,33 @@ func AllGamesInformation(w http.ResponseWriter, r *http.Request) {
 		log.Println(err)
 		return
 	}
-	games, err := database.GameInfosByUserId(userId)
+	filter, err := gameFilterFromQuery(r.URL.Query())
+	if err != nil {
+		badRequest(err.Error(), w, r)
+		return
+	}
+	games, total, next, err := database.AccessibleGames(userId, filter)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	err = setThumbnailUrls(games)
 	if err != nil {
 		internalServerError(w, r)
 		log.Println(err)
 		return
 	}
+	if err := setPageHeaders(w, total, next); err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
 	ok(games, w, r)
 }
 
-// AskForUpload check if the game save is not lock, then lock it and generate a token
+// AskForUpload check if the game save is not lock, then lock it and generate a token. A user who can only
+// read the game get a token to download it
 func AskForUpload(w http.ResponseWriter, r *http.Request) {
 	userId, err := userIdFromContext(r.Context())
 	if err != nil {
@@ -127,7 +245,26 @@ func AskForUpload(w http.ResponseWriter, r *http.Request) {
 		log.Println(err)
 		return
 	}
-	token, err := upload.AskForUpload(userId, gameInfo.GameId)
+	info, err := tokenFromContext(r.Context())
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	if !info.HasScope(authentication.ScopeRead, 0) && !info.HasScope(authentication.ScopeUpload, gameInfo.GameId) {
+		forbidden(w, r)
+		return
+	}
+	// a user who can only read the game, or a token without the upload scope, get a token to download the
+	// save that does not lock the game
+	write := info.HasScope(authentication.ScopeUpload, gameInfo.GameId)
+	var token *upload.GameUploadToken
+	if write {
+		token, err = upload.AskForUpload(userId, gameInfo.GameId)
+	}
+	if !write || errors.Is(err, upload.ErrReadOnlyGame) {
+		token, err = upload.AskForDownload(userId, gameInfo.GameId)
+	}
 	if err != nil {
 		ok(LockError{Message: err.Error()}, w, r)
 		return
@@ -135,7 +272,8 @@ func AskForUpload(w http.ResponseWriter, r *http.Request) {
 	ok(token, w, r)
 }
 
-// UploadSave upload the game save archive to the storage folder
+// UploadSave upload the game save archive to the storage folder. The form can have a note, labels separated
+// by a comma, the name of the device and a screenshot, they are saved with the revision
 func UploadSave(w http.ResponseWriter, r *http.Request) {
 	userId, err := userIdFromContext(r.Context())
 	if err != nil {
@@ -160,13 +298,17 @@ func UploadSave(w http.ResponseWriter, r *http.Request) {
 		badRequest("The header X-Hash is missing", w, r)
 		return
 	}
-	game, err := database.GameInfoById(userId, gameId)
+	game, err := database.AccessibleGameById(userId, gameId)
 	if err != nil {
 		internalServerError(w, r)
 		log.Println(err)
 		return
 	}
-	file, _, err := r.FormFile("file")
+	if game.ShareLevel == database.ShareRead {
+		forbidden(w, r)
+		return
+	}
+	file, header, err := r.FormFile("file")
 	if err != nil {
 		internalServerError(w, r)
 		log.Println(err)
@@ -178,6 +320,39 @@ func UploadSave(w http.ResponseWriter, r *http.Request) {
 			log.Println(err)
 		}
 	}(file)
+	if err := upload.CheckQuota(game, header.Size); err != nil {
+		if errors.Is(err, upload.ErrQuotaExceeded) {
+			badRequest(err.Error(), w, r)
+			return
+		}
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	revision := &database.Revision{
+		UserId:    userId,
+		Device:    r.FormValue("device"),
+		Note:      r.FormValue("note"),
+		LabelList: strings.Split(r.FormValue("labels"), ","),
+	}
+	if len(revision.Device) == 0 {
+		revision.Device = clientInfo("", r).Device
+	}
+	if err := checkRevisionInfo(revision); err != nil {
+		badRequest(err.Error(), w, r)
+		return
+	}
+	var screenshot image.Image
+	if screenshotFile, _, err := r.FormFile("screenshot"); err == nil {
+		screenshot, err = upload.DecodeScreenshot(screenshotFile)
+		if closeErr := screenshotFile.Close(); closeErr != nil {
+			log.Println(closeErr)
+		}
+		if err != nil {
+			badRequest(err.Error(), w, r)
+			return
+		}
+	}
 	err = upload.UploadToCache(file, game)
 	if err != nil {
 		internalServerError(w, r)
@@ -190,12 +365,18 @@ func UploadSave(w http.ResponseWriter, r *http.Request) {
 		log.Println(err)
 		return
 	}
-	err = database.UpdateGameRevision(game, hash)
+	err = database.UpdateGameRevision(game, hash, revision)
 	if err != nil {
 		internalServerError(w, r)
 		log.Println(err)
 		return
 	}
+	if screenshot != nil {
+		// the save is stored, so a screenshot that cannot be written does not fail the upload
+		if _, err := upload.SaveScreenshot(game, game.Revision, screenshot); err != nil {
+			log.Println(err)
+		}
+	}
 	payload := &successMessage{
 		Message:   "Game uploaded",
 		Timestamp: time.Now(),
@@ -218,14 +399,14 @@ func Download(w http.ResponseWriter, r *http.Request) {
 		log.Println(err)
 		return
 	}
-	defer upload.UnlockGame(gameId)
-	game, err := database.GameInfoById(userId, gameId)
+	defer upload.ReleaseToken(r.Header.Get("X-Upload-Key"))
+	game, err := database.AccessibleGameById(userId, gameId)
 	if err != nil {
 		internalServerError(w, r)
 		log.Println(err)
 		return
 	}
-	savePath := filepath.Join(config.Path().Storage, strconv.Itoa(userId), game.PathStorage)
+	savePath := upload.SavePath(game)
 
 	if _, err := os.Stat(savePath); err == nil {
 		hash, err := upload.FileHash(savePath)
@@ -298,12 +479,17 @@ func ChangePassword(w http.ResponseWriter, r *http.Request) {
 		badRequest("password are not the same", w, r)
 		return
 	}
-	err = database.ChangePassword(userId, []byte(newPassword.Password))
+	sessionId, err := sessionIdFromContext(r.Context())
 	if err != nil {
 		internalServerError(w, r)
 		log.Println(err)
 		return
 	}
+	err = authentication.ChangePassword(userId, newPassword.Password, sessionId)
+	if err != nil {
+		badRequest(err.Error(), w, r)
+		return
+	}
 	payload := &successMessage{
 		Message:   "Password changed",
 		Timestamp: time.Now(),
@@ -332,7 +518,13 @@ func RemoveGame(w http.ResponseWriter, r *http.Request) {
 		log.Println(err)
 		return
 	}
-	err = upload.RemoveGame(userId, game)
+	err = upload.RemoveGame(game)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	err = database.RemoveAllGameShares(game)
 	if err != nil {
 		internalServerError(w, r)
 		log.Println(err)
@@ -346,3 +538,679 @@ func RemoveGame(w http.ResponseWriter, r *http.Request) {
 	}
 	ok(game, w, r)
 }
+
+// ShareGame give to another user the access to a game save, read-only or read-write
+func ShareGame(w http.ResponseWriter, r *http.Request) {
+	userId, err := userIdFromContext(r.Context())
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	shareInfo := new(ShareGameInfo)
+	err = json.Unmarshal(body, shareInfo)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	if shareInfo.Level != database.ShareRead && shareInfo.Level != database.ShareWrite {
+		badRequest("The level must be 'read' or 'write'", w, r)
+		return
+	}
+	game, err := database.GameInfoById(userId, shareInfo.GameId)
+	if err != nil {
+		notFound(err.Error(), w, r)
+		log.Println(err)
+		return
+	}
+	user, err := database.UserByUsername(shareInfo.Username)
+	if err != nil {
+		notFound("This user does not exist", w, r)
+		return
+	}
+	if user.ID == userId {
+		badRequest("A game cannot be shared with its owner", w, r)
+		return
+	}
+	share, err := database.SaveGameShare(game, user, shareInfo.Level)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	ok(share, w, r)
+}
+
+// GameShares list the users that have access to a game save of the user
+func GameShares(w http.ResponseWriter, r *http.Request) {
+	userId, err := userIdFromContext(r.Context())
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	queryId := chi.URLParam(r, "id")
+	id, err := strconv.Atoi(queryId)
+	if err != nil {
+		badRequest("Game ID missing or not an int", w, r)
+		log.Println(err)
+		return
+	}
+	game, err := database.GameInfoById(userId, id)
+	if err != nil {
+		notFound(err.Error(), w, r)
+		log.Println(err)
+		return
+	}
+	shares, err := database.GameSharesByGameId(game.ID)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	ok(shares, w, r)
+}
+
+// RemoveGameShare revoke the access of a user to a game save. The owner can revoke any share,
+// the other users can only leave a game shared with them
+func RemoveGameShare(w http.ResponseWriter, r *http.Request) {
+	userId, err := userIdFromContext(r.Context())
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	gameId, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		badRequest("Game ID missing or not an int", w, r)
+		log.Println(err)
+		return
+	}
+	shareUserId, err := strconv.Atoi(chi.URLParam(r, "userId"))
+	if err != nil {
+		badRequest("User ID missing or not an int", w, r)
+		log.Println(err)
+		return
+	}
+	if shareUserId != userId {
+		if _, err := database.GameInfoById(userId, gameId); err != nil {
+			notFound(err.Error(), w, r)
+			log.Println(err)
+			return
+		}
+	}
+	err = database.RemoveGameShare(gameId, shareUserId)
+	if err != nil {
+		notFound(err.Error(), w, r)
+		log.Println(err)
+		return
+	}
+	payload := &successMessage{
+		Message:   "Share removed",
+		Timestamp: time.Now(),
+		Status:    200,
+	}
+	ok(payload, w, r)
+}
+
+// CreateShareLink create a public link to the current revision of a game save, a user who can only read
+// the game cannot share it
+func CreateShareLink(w http.ResponseWriter, r *http.Request) {
+	userId, err := userIdFromContext(r.Context())
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	linkInfo := new(authentication.NewShareLink)
+	err = json.Unmarshal(body, linkInfo)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	link, err := authentication.CreateShareLink(userId, linkInfo)
+	if err != nil {
+		if err == authentication.ErrReadOnlyGame {
+			forbidden(w, r)
+			return
+		}
+		badRequest(err.Error(), w, r)
+		return
+	}
+	ok(link, w, r)
+}
+
+func ShareLinks(w http.ResponseWriter, r *http.Request) {
+	userId, err := userIdFromContext(r.Context())
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	links, err := authentication.ShareLinks(userId)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	ok(links, w, r)
+}
+
+// GameShareLinks list the share links of a game, for the owner of the game or an admin of its group
+func GameShareLinks(w http.ResponseWriter, r *http.Request) {
+	userId, err := userIdFromContext(r.Context())
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		badRequest("Game ID missing or not an int", w, r)
+		log.Println(err)
+		return
+	}
+	game, err := database.AccessibleGameById(userId, id)
+	if err != nil {
+		notFound(err.Error(), w, r)
+		log.Println(err)
+		return
+	}
+	if !database.CanManageGame(userId, game) {
+		forbidden(w, r)
+		return
+	}
+	links, err := database.ShareLinksByGameId(game.ID)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	ok(links, w, r)
+}
+
+// RevokeShareLink remove a share link created by the user, or a share link of a game they own
+func RevokeShareLink(w http.ResponseWriter, r *http.Request) {
+	userId, err := userIdFromContext(r.Context())
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	queryId := chi.URLParam(r, "id")
+	id, err := strconv.Atoi(queryId)
+	if err != nil {
+		badRequest("Link ID missing or not an int", w, r)
+		log.Println(err)
+		return
+	}
+	err = authentication.RevokeShareLink(userId, id)
+	if err != nil {
+		notFound(err.Error(), w, r)
+		log.Println(err)
+		return
+	}
+	payload := &successMessage{
+		Message:   "Share link revoked",
+		Timestamp: time.Now(),
+		Status:    200,
+	}
+	ok(payload, w, r)
+}
+
+// DownloadShareLink send the snapshot of a share link. The password is given with the header X-Link-Password,
+// or in the body of a POST as a form or as JSON. It is never read from the URL, the URLs are logged
+func DownloadShareLink(w http.ResponseWriter, r *http.Request) {
+	password := r.Header.Get("X-Link-Password")
+	if len(password) == 0 && r.Method == http.MethodPost {
+		r.Body = http.MaxBytesReader(w, r.Body, 1<<16)
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				badRequest(err.Error(), w, r)
+				return
+			}
+			linkPassword := new(ShareLinkPassword)
+			if err := json.Unmarshal(body, linkPassword); err != nil {
+				badRequest(err.Error(), w, r)
+				return
+			}
+			password = linkPassword.Password
+		} else {
+			password = r.PostFormValue("password")
+		}
+	}
+	link, err := authentication.OpenShareLink(chi.URLParam(r, "token"), password, clientInfo("", r).Ip)
+	if err != nil {
+		var attemptsErr *authentication.AttemptsError
+		if errors.As(err, &attemptsErr) {
+			tooManyRequests(attemptsErr.Until, w, r)
+			return
+		}
+		notFound(err.Error(), w, r)
+		return
+	}
+	file, err := os.Open(upload.LinkPath(link))
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	defer func(file *os.File) {
+		err := file.Close()
+		if err != nil {
+			log.Println(err)
+		}
+	}(file)
+	w.Header().Add("X-Game-Save-Hash", link.Hash)
+	w.Header().Add("Content-Disposition", fmt.Sprintf("attachment; filename=\"save-%d-rev%d.bin\"", link.GameId, link.Revision))
+	_, err = io.Copy(w, file)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// UpdateRevision change the note, the labels, the device or the pin of a revision
+func UpdateRevision(w http.ResponseWriter, r *http.Request) {
+	userId, err := userIdFromContext(r.Context())
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	revisionInfo := new(RevisionInfo)
+	err = json.Unmarshal(body, revisionInfo)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	game, err := database.AccessibleGameById(userId, revisionInfo.GameId)
+	if err != nil {
+		notFound(err.Error(), w, r)
+		log.Println(err)
+		return
+	}
+	if game.ShareLevel == database.ShareRead {
+		forbidden(w, r)
+		return
+	}
+	revision, err := database.RevisionByNumber(game.ID, revisionInfo.Revision)
+	if err != nil {
+		notFound(err.Error(), w, r)
+		log.Println(err)
+		return
+	}
+	if revisionInfo.Note != nil {
+		revision.Note = *revisionInfo.Note
+	}
+	if revisionInfo.Labels != nil {
+		revision.LabelList = *revisionInfo.Labels
+	}
+	if revisionInfo.Device != nil {
+		revision.Device = *revisionInfo.Device
+	}
+	if revisionInfo.Pinned != nil {
+		revision.Pinned = *revisionInfo.Pinned
+	}
+	if err := checkRevisionInfo(revision); err != nil {
+		badRequest(err.Error(), w, r)
+		return
+	}
+	err = database.SaveRevision(revision)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	ok(revision, w, r)
+}
+
+// checkRevisionInfo clean the labels and check the length of the information of a revision
+func checkRevisionInfo(revision *database.Revision) error {
+	if utf8.RuneCountInString(revision.Note) > 1000 {
+		return errors.New("the note cannot be longer than 1000 characters")
+	}
+	if utf8.RuneCountInString(revision.Device) > 255 {
+		revision.Device = string([]rune(revision.Device)[:255])
+	}
+	labels := make([]string, 0, len(revision.LabelList))
+	for _, label := range revision.LabelList {
+		label = strings.TrimSpace(label)
+		if len(label) == 0 {
+			continue
+		}
+		if strings.Contains(label, ",") {
+			return errors.New("a label cannot contain a comma")
+		}
+		if utf8.RuneCountInString(label) > 50 {
+			return errors.New("a label cannot be longer than 50 characters")
+		}
+		labels = append(labels, label)
+	}
+	if len(labels) > 20 {
+		return errors.New("a revision cannot have more than 20 labels")
+	}
+	revision.LabelList = labels
+	return nil
+}
+
+// UpdateGame change the name, the catalog game or the notes of a game
+func UpdateGame(w http.ResponseWriter, r *http.Request) {
+	userId, err := userIdFromContext(r.Context())
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	gameInfo := new(UpdateGameInfo)
+	err = json.Unmarshal(body, gameInfo)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	game, err := database.AccessibleGameById(userId, gameInfo.GameId)
+	if err != nil {
+		notFound(err.Error(), w, r)
+		log.Println(err)
+		return
+	}
+	if game.ShareLevel == database.ShareRead {
+		forbidden(w, r)
+		return
+	}
+	if (gameInfo.Name != nil || gameInfo.CatalogId != nil) && !database.CanManageGame(userId, game) {
+		// a user with a write share can edit the notes, but only the owner can rename the game or change its catalog game
+		forbidden(w, r)
+		return
+	}
+	if gameInfo.Name != nil {
+		name := strings.TrimSpace(*gameInfo.Name)
+		if len(name) == 0 || utf8.RuneCountInString(name) > 255 {
+			badRequest("The name must have between 1 and 255 characters", w, r)
+			return
+		}
+		game.Name = name
+	}
+	if gameInfo.CatalogId != nil {
+		if *gameInfo.CatalogId == 0 {
+			game.CatalogId = nil
+		} else {
+			if _, err := database.CatalogGameById(*gameInfo.CatalogId); err != nil {
+				notFound("This catalog game does not exist", w, r)
+				return
+			}
+			game.CatalogId = gameInfo.CatalogId
+		}
+	}
+	if gameInfo.Notes != nil {
+		if utf8.RuneCountInString(*gameInfo.Notes) > 5000 {
+			badRequest("The notes cannot be longer than 5000 characters", w, r)
+			return
+		}
+		game.Notes = *gameInfo.Notes
+	}
+	err = database.UpdateGame(game)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	ok(game, w, r)
+}
+
+// MoveSave move the current save of a game to another game without upload, it becomes a new revision of
+// the destination. With merge, the revisions, the screenshots and the save path templates of the source are
+// given to the destination, then the source game is removed, so only a user who can remove it can merge it
+func MoveSave(w http.ResponseWriter, r *http.Request) {
+	userId, err := userIdFromContext(r.Context())
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	moveInfo := new(MoveSaveInfo)
+	err = json.Unmarshal(body, moveInfo)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	if moveInfo.From == moveInfo.To {
+		badRequest("The save cannot be moved to the same game", w, r)
+		return
+	}
+	from, err := database.AccessibleGameById(userId, moveInfo.From)
+	if err != nil {
+		notFound(err.Error(), w, r)
+		log.Println(err)
+		return
+	}
+	to, err := database.AccessibleGameById(userId, moveInfo.To)
+	if err != nil {
+		notFound(err.Error(), w, r)
+		log.Println(err)
+		return
+	}
+	if from.ShareLevel == database.ShareRead || to.ShareLevel == database.ShareRead {
+		forbidden(w, r)
+		return
+	}
+	if !database.CanManageGame(userId, from) {
+		// moving the save empty the source game, so a user with a write share cannot take the save of the owner
+		forbidden(w, r)
+		return
+	}
+	if !from.Available && !moveInfo.Merge {
+		badRequest("The game does not have a save to move", w, r)
+		return
+	}
+	revision := &database.Revision{
+		UserId: userId,
+		Device: clientInfo("", r).Device,
+		Note:   fmt.Sprintf("Moved from %s", from.Name),
+	}
+	if err := checkRevisionInfo(revision); err != nil {
+		badRequest(err.Error(), w, r)
+		return
+	}
+	if moveInfo.Merge {
+		err = upload.MergeSave(from, to, revision)
+	} else {
+		err = upload.MoveSave(from, to, revision)
+	}
+	if err != nil {
+		badRequest(err.Error(), w, r)
+		log.Println(err)
+		return
+	}
+	if moveInfo.Merge {
+		err = upload.RemoveGame(from)
+		if err != nil {
+			internalServerError(w, r)
+			log.Println(err)
+			return
+		}
+		err = database.RemoveAllGameShares(from)
+		if err != nil {
+			internalServerError(w, r)
+			log.Println(err)
+			return
+		}
+		err = database.RemoveGame(from)
+		if err != nil {
+			internalServerError(w, r)
+			log.Println(err)
+			return
+		}
+	}
+	ok(to, w, r)
+}
+
+// SavePathTemplates get the save path templates of a game, the query os keep only the templates of this OS.
+// When the game does not have templates, the templates of its catalog game are given
+func SavePathTemplates(w http.ResponseWriter, r *http.Request) {
+	userId, err := userIdFromContext(r.Context())
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		badRequest("Game ID missing or not an int", w, r)
+		log.Println(err)
+		return
+	}
+	game, err := database.AccessibleGameById(userId, id)
+	if err != nil {
+		notFound(err.Error(), w, r)
+		log.Println(err)
+		return
+	}
+	templates, err := savePathTemplates(game)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	templateOs := strings.ToLower(r.URL.Query().Get("os"))
+	res := make([]*database.SavePathTemplate, 0, len(templates))
+	for _, template := range templates {
+		if len(templateOs) == 0 || template.Os == templateOs {
+			res = append(res, template)
+		}
+	}
+	ok(res, w, r)
+}
+
+// SetSavePathTemplates replace the save path templates of a game for an OS
+func SetSavePathTemplates(w http.ResponseWriter, r *http.Request) {
+	userId, err := userIdFromContext(r.Context())
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	pathsInfo := new(SavePathsInfo)
+	err = json.Unmarshal(body, pathsInfo)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	game, err := database.AccessibleGameById(userId, pathsInfo.GameId)
+	if err != nil {
+		notFound(err.Error(), w, r)
+		log.Println(err)
+		return
+	}
+	if game.ShareLevel == database.ShareRead {
+		forbidden(w, r)
+		return
+	}
+	templateOs := strings.ToLower(strings.TrimSpace(pathsInfo.Os))
+	if err := catalog.CheckTemplateOs(templateOs); err != nil {
+		badRequest(err.Error(), w, r)
+		return
+	}
+	if len(pathsInfo.Paths) > 50 {
+		badRequest("A game cannot have more than 50 save paths for an OS", w, r)
+		return
+	}
+	templates := make([]*database.SavePathTemplate, 0, len(pathsInfo.Paths))
+	for _, path := range pathsInfo.Paths {
+		path = strings.TrimSpace(path)
+		if err := catalog.CheckTemplate(templateOs, path); err != nil {
+			badRequest(err.Error(), w, r)
+			return
+		}
+		templates = append(templates, &database.SavePathTemplate{
+			Path: path,
+		})
+	}
+	err = database.SetSavePathTemplates(game.ID, templateOs, templates)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	game.SavePaths, err = savePathTemplates(game)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	ok(game.SavePaths, w, r)
+}
+
+// TemplateVariables list the variables of the save path templates with their description
+func TemplateVariables(w http.ResponseWriter, r *http.Request) {
+	ok(catalog.TemplateVariables, w, r)
+}
+
+// savePathTemplates get the templates of the game, the templates of its catalog game are used for the OS
+// that do not have templates
+func savePathTemplates(game *database.Game) ([]*database.SavePathTemplate, error) {
+	templates, err := database.SavePathTemplatesByGameId(game.ID)
+	if err != nil {
+		return nil, err
+	}
+	if game.CatalogId == nil {
+		return templates, nil
+	}
+	catalogGame, err := database.CatalogGameById(*game.CatalogId)
+	if err != nil {
+		// the catalog game may have been removed, the game simply has no default
+		return templates, nil
+	}
+	stored := make(map[string]bool)
+	for _, template := range templates {
+		stored[template.Os] = true
+	}
+	for _, template := range catalog.CatalogTemplates(catalogGame) {
+		if !stored[template.Os] {
+			templates = append(templates, template)
+		}
+	}
+	return templates, nil
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"embed"
+	"encoding/json"
+	"gopkg.in/yaml.v3"
+	"log"
+	"net/http"
+)
+
+// docs is the OpenAPI specification of the API and the page that display it
+//
+//go:embed docs/openapi.yaml docs/index.html
+var docs embed.FS
+
+// OpenApiYaml send the OpenAPI specification of the API
+func OpenApiYaml(w http.ResponseWriter, r *http.Request) {
+	data, err := docs.ReadFile("docs/openapi.yaml")
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/yaml")
+	if _, err := w.Write(data); err != nil {
+		log.Println(err)
+	}
+}
+
+// OpenApiJson send the OpenAPI specification of the API converted to JSON
+func OpenApiJson(w http.ResponseWriter, r *http.Request) {
+	data, err := docs.ReadFile("docs/openapi.yaml")
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	var spec interface{}
+	if err := yaml.Unmarshal(data, &spec); err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	payload, err := json.Marshal(spec)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(payload); err != nil {
+		log.Println(err)
+	}
+}
+
+// ApiDocs send the page that display the OpenAPI specification
+func ApiDocs(w http.ResponseWriter, r *http.Request) {
+	data, err := docs.ReadFile("docs/index.html")
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if _, err := w.Write(data); err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"github.com/go-chi/chi/v5"
+	"gopkg.in/yaml.v3"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+)
+
+const apiPrefix = "/api/v1"
+
+// openApiSpec is the part of the specification used to check the routes
+type openApiSpec struct {
+	Paths map[string]map[string]interface{} `yaml:"paths"`
+}
+
+// specOperations list the operations of the OpenAPI specification as "METHOD /path"
+func specOperations(t *testing.T) map[string]bool {
+	data, err := docs.ReadFile("docs/openapi.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := new(openApiSpec)
+	if err := yaml.Unmarshal(data, spec); err != nil {
+		t.Fatal(err)
+	}
+	operations := make(map[string]bool)
+	for path, methods := range spec.Paths {
+		for method := range methods {
+			switch method {
+			case "get", "post", "put", "patch", "delete":
+				operations[strings.ToUpper(method)+" "+path] = true
+			}
+		}
+	}
+	return operations
+}
+
+// routerOperations list the routes of the API served by the router as "METHOD /path"
+func routerOperations(t *testing.T, router chi.Routes) map[string]bool {
+	operations := make(map[string]bool)
+	err := chi.Walk(router, func(method string, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		if strings.HasPrefix(route, apiPrefix+"/") {
+			path := strings.TrimSuffix(strings.TrimPrefix(route, apiPrefix), "/")
+			operations[method+" "+path] = true
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return operations
+}
+
+func TestOpenApiSpecMatchRoutes(t *testing.T) {
+	spec := specOperations(t)
+	routes := routerOperations(t, newRouter())
+	if len(routes) == 0 {
+		t.Fatal("the router has no route")
+	}
+	var missing, unknown []string
+	for route := range routes {
+		if !spec[route] {
+			missing = append(missing, route)
+		}
+	}
+	for operation := range spec {
+		if !routes[operation] {
+			unknown = append(unknown, operation)
+		}
+	}
+	sort.Strings(missing)
+	sort.Strings(unknown)
+	for _, route := range missing {
+		t.Errorf("the route %s is missing from the OpenAPI specification", route)
+	}
+	for _, operation := range unknown {
+		t.Errorf("the operation %s of the OpenAPI specification is not served", operation)
+	}
+}
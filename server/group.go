@@ -0,0 +1,303 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/go-chi/chi/v5"
+	"io"
+	"log"
+	"net/http"
+	"opensavecloudserver/database"
+	"opensavecloudserver/upload"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type NewGroupInfo struct {
+	Name string `json:"name"`
+}
+
+type GroupMemberInfo struct {
+	Username string `json:"username"`
+	Role     string `json:"role"`
+}
+
+// CreateGroup create a group, the user become its admin
+func CreateGroup(w http.ResponseWriter, r *http.Request) {
+	userId, err := userIdFromContext(r.Context())
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	groupInfo := new(NewGroupInfo)
+	err = json.Unmarshal(body, groupInfo)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	name := strings.TrimSpace(groupInfo.Name)
+	if len(name) == 0 {
+		badRequest("The name of the group is missing", w, r)
+		return
+	}
+	group, err := database.CreateGroup(userId, name)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	ok(group, w, r)
+}
+
+// Groups list the groups of the user
+func Groups(w http.ResponseWriter, r *http.Request) {
+	userId, err := userIdFromContext(r.Context())
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	groups, err := database.GroupsByUserId(userId)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	ok(groups, w, r)
+}
+
+// GroupInfo give the members of a group and the storage used by its games
+func GroupInfo(w http.ResponseWriter, r *http.Request) {
+	group, member, err := groupFromRequest(r)
+	if err != nil {
+		notFound(err.Error(), w, r)
+		log.Println(err)
+		return
+	}
+	group.Role = member.Role
+	group.Members, err = database.GroupMembersByGroupId(group.ID)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	group.StorageUsed, err = upload.GroupStorageUsage(group.ID)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	ok(group, w, r)
+}
+
+// RemoveGroup remove the group with all its games, only an admin of the group can do it
+func RemoveGroup(w http.ResponseWriter, r *http.Request) {
+	group, member, err := groupFromRequest(r)
+	if err != nil {
+		notFound(err.Error(), w, r)
+		log.Println(err)
+		return
+	}
+	if member.Role != database.GroupRoleAdmin {
+		forbidden(w, r)
+		return
+	}
+	links, err := database.ShareLinksOfGroupGames(group.ID)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	err = upload.RemoveLinkSnapshots(links)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	err = upload.RemoveGroupFolders(group.ID)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	err = database.RemoveGroup(group)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	ok(group, w, r)
+}
+
+// SaveGroupMember add a user to the group or change their role, only an admin of the group can do it
+func SaveGroupMember(w http.ResponseWriter, r *http.Request) {
+	group, member, err := groupFromRequest(r)
+	if err != nil {
+		notFound(err.Error(), w, r)
+		log.Println(err)
+		return
+	}
+	if member.Role != database.GroupRoleAdmin {
+		forbidden(w, r)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	memberInfo := new(GroupMemberInfo)
+	err = json.Unmarshal(body, memberInfo)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	if memberInfo.Role != database.GroupRoleAdmin && memberInfo.Role != database.GroupRoleMember && memberInfo.Role != database.GroupRoleViewer {
+		badRequest("The role must be 'admin', 'member' or 'viewer'", w, r)
+		return
+	}
+	user, err := database.UserByUsername(memberInfo.Username)
+	if err != nil {
+		notFound("This user does not exist", w, r)
+		return
+	}
+	if current, err := database.GroupMemberById(group.ID, user.ID); err == nil && current.Role == database.GroupRoleAdmin && memberInfo.Role != database.GroupRoleAdmin {
+		if lastGroupAdmin(group.ID, w, r) {
+			return
+		}
+	}
+	saved, err := database.SaveGroupMember(group.ID, user, memberInfo.Role)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	ok(saved, w, r)
+}
+
+// RemoveGroupMember remove a user from the group. An admin can remove any member, the other members
+// can only leave the group
+func RemoveGroupMember(w http.ResponseWriter, r *http.Request) {
+	group, member, err := groupFromRequest(r)
+	if err != nil {
+		notFound(err.Error(), w, r)
+		log.Println(err)
+		return
+	}
+	memberId, err := strconv.Atoi(chi.URLParam(r, "userId"))
+	if err != nil {
+		badRequest("User ID missing or not an int", w, r)
+		log.Println(err)
+		return
+	}
+	if memberId != member.UserId && member.Role != database.GroupRoleAdmin {
+		forbidden(w, r)
+		return
+	}
+	removed, err := database.GroupMemberById(group.ID, memberId)
+	if err != nil {
+		notFound(err.Error(), w, r)
+		log.Println(err)
+		return
+	}
+	if removed.Role == database.GroupRoleAdmin && lastGroupAdmin(group.ID, w, r) {
+		return
+	}
+	err = database.RemoveGroupMember(removed)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	payload := &successMessage{
+		Message:   "Member removed",
+		Timestamp: time.Now(),
+		Status:    200,
+	}
+	ok(payload, w, r)
+}
+
+// RemoveGroupGame remove a game of the group, only an admin of the group can do it
+func RemoveGroupGame(w http.ResponseWriter, r *http.Request) {
+	group, member, err := groupFromRequest(r)
+	if err != nil {
+		notFound(err.Error(), w, r)
+		log.Println(err)
+		return
+	}
+	if member.Role != database.GroupRoleAdmin {
+		forbidden(w, r)
+		return
+	}
+	gameId, err := strconv.Atoi(chi.URLParam(r, "gameId"))
+	if err != nil {
+		badRequest("Game ID missing or not an int", w, r)
+		log.Println(err)
+		return
+	}
+	game, err := database.AccessibleGameById(member.UserId, gameId)
+	if err != nil || game.GroupId == nil || *game.GroupId != group.ID {
+		notFound("This game does not exist in the group", w, r)
+		return
+	}
+	err = upload.RemoveGame(game)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	err = database.RemoveGame(game)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	ok(game, w, r)
+}
+
+// groupFromRequest give the group of the URL and the membership of the user, an error is returned
+// if the user is not a member of the group
+func groupFromRequest(r *http.Request) (*database.Group, *database.GroupMember, error) {
+	userId, err := userIdFromContext(r.Context())
+	if err != nil {
+		return nil, nil, err
+	}
+	groupId, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		return nil, nil, err
+	}
+	member, err := database.GroupMemberById(groupId, userId)
+	if err != nil {
+		return nil, nil, err
+	}
+	group, err := database.GroupById(groupId)
+	if err != nil {
+		return nil, nil, err
+	}
+	return group, member, nil
+}
+
+// lastGroupAdmin send a bad request if the group has only one admin, a group cannot be left without admin
+func lastGroupAdmin(groupId int, w http.ResponseWriter, r *http.Request) bool {
+	count, err := database.CountGroupAdmins(groupId)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return true
+	}
+	if count <= 1 {
+		badRequest("The group must keep at least one admin", w, r)
+		return true
+	}
+	return false
+}
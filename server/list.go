@@ -0,0 +1,128 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"opensavecloudserver/database"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const maxPageLimit = 500
+
+// pageFromQuery read the sort, the cursor and the limit of a list. The sort is one of the sorts of the list,
+// with a leading '-' for the descending order
+func pageFromQuery(query url.Values, sorts ...string) (database.Page, error) {
+	page := database.Page{
+		Sort: query.Get("sort"),
+	}
+	if strings.HasPrefix(page.Sort, "-") {
+		page.Sort = strings.TrimPrefix(page.Sort, "-")
+		page.Desc = true
+	}
+	if len(page.Sort) == 0 {
+		page.Sort = database.SortId
+	}
+	validSort := false
+	for _, sort := range sorts {
+		validSort = validSort || sort == page.Sort
+	}
+	if !validSort {
+		return page, errors.New("the sort must be one of: " + strings.Join(sorts, ", "))
+	}
+	if limit := query.Get("limit"); len(limit) > 0 {
+		var err error
+		page.Limit, err = strconv.Atoi(limit)
+		if err != nil || page.Limit < 1 || page.Limit > maxPageLimit {
+			return page, errors.New("the limit must be an int between 1 and " + strconv.Itoa(maxPageLimit))
+		}
+	}
+	if cursor := query.Get("cursor"); len(cursor) > 0 {
+		data, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil {
+			return page, errors.New("the cursor is not valid")
+		}
+		page.Cursor = new(database.Cursor)
+		if err := json.Unmarshal(data, page.Cursor); err != nil {
+			return page, errors.New("the cursor is not valid")
+		}
+		if page.Sort == database.SortLastUpdate && len(page.Cursor.Value) > 0 {
+			if _, err := time.Parse(time.RFC3339Nano, page.Cursor.Value); err != nil {
+				return page, errors.New("the cursor is not valid")
+			}
+		}
+	}
+	return page, nil
+}
+
+// gameFilterFromQuery read the filter of a list of games: q search the name, available is a boolean and
+// updated_after and updated_before are RFC 3339 dates
+func gameFilterFromQuery(query url.Values) (*database.GameFilter, error) {
+	page, err := pageFromQuery(query, database.SortId, database.SortName, database.SortLastUpdate)
+	if err != nil {
+		return nil, err
+	}
+	filter := &database.GameFilter{
+		Page:   page,
+		Search: strings.TrimSpace(query.Get("q")),
+	}
+	if available := query.Get("available"); len(available) > 0 {
+		value, err := strconv.ParseBool(available)
+		if err != nil {
+			return nil, errors.New("available must be true or false")
+		}
+		filter.Available = &value
+	}
+	filter.UpdatedAfter, err = dateFromQuery(query, "updated_after")
+	if err != nil {
+		return nil, err
+	}
+	filter.UpdatedBefore, err = dateFromQuery(query, "updated_before")
+	if err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
+
+// userFilterFromQuery read the filter of a list of users: q search the username and role is the name of a role
+func userFilterFromQuery(query url.Values) (*database.UserFilter, error) {
+	page, err := pageFromQuery(query, database.SortId, database.SortName)
+	if err != nil {
+		return nil, err
+	}
+	return &database.UserFilter{
+		Page:   page,
+		Search: strings.TrimSpace(query.Get("q")),
+		Role:   strings.TrimSpace(query.Get("role")),
+	}, nil
+}
+
+func dateFromQuery(query url.Values, key string) (*time.Time, error) {
+	value := query.Get(key)
+	if len(value) == 0 {
+		return nil, nil
+	}
+	date, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, errors.New(key + " must be a RFC 3339 date")
+	}
+	return &date, nil
+}
+
+// setPageHeaders give the total number of items and the cursor of the next page, the body stays the list of items
+func setPageHeaders(w http.ResponseWriter, total int64, next *database.Cursor) error {
+	w.Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
+	if next == nil {
+		return nil
+	}
+	data, err := json.Marshal(next)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("X-Next-Cursor", base64.RawURLEncoding.EncodeToString(data))
+	return nil
+}
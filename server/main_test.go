@@ -0,0 +1,51 @@
+package server
+
+import (
+	"flag"
+	"log"
+	"opensavecloudserver/config"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestMain load a configuration that enable every optional route of the server
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "osc-server")
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, folder := range []string{"storage", "cache"} {
+		if err := os.Mkdir(filepath.Join(dir, folder), 0750); err != nil {
+			log.Fatal(err)
+		}
+	}
+	configuration := `
+server:
+  port: 8080
+path:
+  storage: ` + filepath.Join(dir, "storage") + `
+  cache: ` + filepath.Join(dir, "cache") + `
+features:
+  allow_register: true
+authentication:
+  oidc:
+    enabled: true
+    issuer: http://localhost
+    client_id: osc
+    redirect_url: http://localhost/api/v1/oidc/callback
+`
+	path := filepath.Join(dir, "config.yml")
+	if err := os.WriteFile(path, []byte(configuration), 0600); err != nil {
+		log.Fatal(err)
+	}
+	// The flags of the test are parsed before config.Init parse the arguments again
+	flag.Parse()
+	os.Args = []string{os.Args[0], "-config", path}
+	config.Init()
+	code := m.Run()
+	if err := os.RemoveAll(dir); err != nil {
+		log.Println(err)
+	}
+	os.Exit(code)
+}
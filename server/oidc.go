@@ -0,0 +1,50 @@
+package server
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+)
+
+// oidcPage is shown in the browser of the user after the login at the OpenID Connect provider. The user
+// type the code shown by the application, so a login started by someone else cannot be completed
+var oidcPage = template.Must(template.New("oidc").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1">
+  <title>Open Save Cloud</title>
+</head>
+<body>
+  <h1>Open Save Cloud</h1>
+  {{if .Error}}<p><strong>{{.Error}}</strong></p>{{end}}
+  {{if .State}}
+  <form method="post" action="confirm">
+    <p>Type the code shown by the application to finish the login. If you did not start this login from your application, close this page.</p>
+    <input type="hidden" name="state" value="{{.State}}">
+    <label>Code <input type="text" name="user_code" autocomplete="off" required autofocus></label>
+    <button type="submit">Confirm</button>
+  </form>
+  {{else}}
+  <p>{{.Message}}</p>
+  {{end}}
+</body>
+</html>
+`))
+
+type oidcPageData struct {
+	State   string
+	Message string
+	Error   string
+}
+
+// renderOidcPage send the page of the OpenID Connect login with the status
+func renderOidcPage(status int, data *oidcPageData, w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.WriteHeader(status)
+	if err := oidcPage.Execute(w, data); err != nil {
+		log.Println(err)
+	}
+}
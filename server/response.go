@@ -3,7 +3,9 @@ package server
 import (
 	"encoding/json"
 	"log"
+	"math"
 	"net/http"
+	"strconv"
 	"time"
 )
 
@@ -106,6 +108,28 @@ func forbidden(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func tooManyRequests(retryAfter time.Time, w http.ResponseWriter, r *http.Request) {
+	e := httpError{
+		Status:    429,
+		Error:     "Too Many Requests",
+		Message:   "Too many failed attempts, retry later.",
+		Path:      r.RequestURI,
+		Timestamp: time.Now(),
+	}
+
+	payload, err := json.Marshal(e)
+	if err != nil {
+		log.Println(err)
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.Header().Add("Retry-After", strconv.Itoa(int(math.Ceil(time.Until(retryAfter).Seconds()))))
+	w.WriteHeader(429)
+	_, err = w.Write(payload)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
 func ok(obj interface{}, w http.ResponseWriter, _ *http.Request) {
 	payload, err := json.Marshal(obj)
 	if err != nil {
@@ -0,0 +1,208 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"opensavecloudserver/database"
+	"opensavecloudserver/upload"
+	"os"
+	"strconv"
+)
+
+// UploadScreenshot add a PNG or JPEG screenshot to a game, or to one of its revisions with the form value rev.
+// A thumbnail is generated and the previous screenshot is replaced
+func UploadScreenshot(w http.ResponseWriter, r *http.Request) {
+	game, found := screenshotGame(w, r)
+	if !found {
+		return
+	}
+	if game.ShareLevel == database.ShareRead {
+		forbidden(w, r)
+		return
+	}
+	revision, err := screenshotRevision(r.FormValue("rev"), game)
+	if err != nil {
+		badRequest(err.Error(), w, r)
+		return
+	}
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		badRequest("The screenshot is missing", w, r)
+		log.Println(err)
+		return
+	}
+	defer func(file multipart.File) {
+		err := file.Close()
+		if err != nil {
+			log.Println(err)
+		}
+	}(file)
+	img, err := upload.DecodeScreenshot(file)
+	if err != nil {
+		badRequest(err.Error(), w, r)
+		return
+	}
+	screenshot, err := upload.SaveScreenshot(game, revision, img)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	ok(screenshot, w, r)
+}
+
+// Screenshot send the screenshot of a game or of a revision, the query size=thumbnail send the thumbnail
+func Screenshot(w http.ResponseWriter, r *http.Request) {
+	game, found := screenshotGame(w, r)
+	if !found {
+		return
+	}
+	revision, err := screenshotRevision(r.URL.Query().Get("rev"), game)
+	if err != nil {
+		badRequest(err.Error(), w, r)
+		return
+	}
+	screenshot, err := database.ScreenshotByRevision(game.ID, revision)
+	if err != nil {
+		notFound("This screenshot does not exist", w, r)
+		return
+	}
+	file, err := os.Open(upload.ScreenshotPath(game, screenshot, r.URL.Query().Get("size") == "thumbnail"))
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	defer func(file *os.File) {
+		err := file.Close()
+		if err != nil {
+			log.Println(err)
+		}
+	}(file)
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	_, err = io.Copy(w, file)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// RemoveScreenshot remove the screenshot of a game or of a revision
+func RemoveScreenshot(w http.ResponseWriter, r *http.Request) {
+	game, found := screenshotGame(w, r)
+	if !found {
+		return
+	}
+	if game.ShareLevel == database.ShareRead {
+		forbidden(w, r)
+		return
+	}
+	revision, err := screenshotRevision(r.URL.Query().Get("rev"), game)
+	if err != nil {
+		badRequest(err.Error(), w, r)
+		return
+	}
+	screenshot, err := database.ScreenshotByRevision(game.ID, revision)
+	if err != nil {
+		notFound("This screenshot does not exist", w, r)
+		return
+	}
+	err = upload.RemoveScreenshot(game, screenshot)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	err = database.RemoveScreenshot(screenshot)
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return
+	}
+	ok(screenshot, w, r)
+}
+
+// screenshotGame get the game of the URL the user can access, the response is sent when it is not found
+func screenshotGame(w http.ResponseWriter, r *http.Request) (*database.Game, bool) {
+	userId, err := userIdFromContext(r.Context())
+	if err != nil {
+		internalServerError(w, r)
+		log.Println(err)
+		return nil, false
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		badRequest("Game ID missing or not an int", w, r)
+		log.Println(err)
+		return nil, false
+	}
+	game, err := database.AccessibleGameById(userId, id)
+	if err != nil {
+		notFound(err.Error(), w, r)
+		log.Println(err)
+		return nil, false
+	}
+	return game, true
+}
+
+// screenshotRevision read the revision of a screenshot, an empty value is the screenshot of the game
+func screenshotRevision(value string, game *database.Game) (int, error) {
+	if len(value) == 0 {
+		return 0, nil
+	}
+	revision, err := strconv.Atoi(value)
+	if err != nil || revision < 0 {
+		return 0, errors.New("the revision must be a positive int")
+	}
+	if revision > 0 {
+		if _, err := database.RevisionByNumber(game.ID, revision); err != nil {
+			return 0, fmt.Errorf("the revision %d does not exist", revision)
+		}
+	}
+	return revision, nil
+}
+
+// setThumbnailUrls give the URL of the thumbnails to the games and to their revisions. The thumbnail of a game
+// is its own screenshot, or else the screenshot of its current revision
+func setThumbnailUrls(games []*database.Game) error {
+	ids := make([]int, 0, len(games))
+	for _, game := range games {
+		ids = append(ids, game.ID)
+	}
+	screenshots, err := database.ScreenshotsByGameIds(ids)
+	if err != nil {
+		return err
+	}
+	revisions := make(map[int]map[int]bool)
+	for _, screenshot := range screenshots {
+		if revisions[screenshot.GameId] == nil {
+			revisions[screenshot.GameId] = make(map[int]bool)
+		}
+		revisions[screenshot.GameId][screenshot.Revision] = true
+	}
+	for _, game := range games {
+		if revisions[game.ID][0] {
+			game.ThumbnailUrl = thumbnailUrl(game.ID, 0)
+		} else if revisions[game.ID][game.Revision] {
+			game.ThumbnailUrl = thumbnailUrl(game.ID, game.Revision)
+		}
+		for _, revision := range game.Revisions {
+			if revisions[game.ID][revision.Revision] {
+				revision.ThumbnailUrl = thumbnailUrl(game.ID, revision.Revision)
+			}
+		}
+	}
+	return nil
+}
+
+func thumbnailUrl(gameId, revision int) string {
+	if revision == 0 {
+		return fmt.Sprintf("/api/v1/game/%d/screenshot?size=thumbnail", gameId)
+	}
+	return fmt.Sprintf("/api/v1/game/%d/screenshot?rev=%d&size=thumbnail", gameId, revision)
+}
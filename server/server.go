@@ -17,62 +17,156 @@ import (
 type ContextKey string
 
 const (
-	UserIdKey ContextKey = "userId"
-	GameIdKey ContextKey = "gameId"
+	UserIdKey    ContextKey = "userId"
+	GameIdKey    ContextKey = "gameId"
+	SessionIdKey ContextKey = "sessionId"
+	TokenKey     ContextKey = "token"
+	RoleKey      ContextKey = "role"
 )
 
 // Serve start the http server
 func Serve() {
+	router := newRouter()
+	log.Println("Server is listening...")
+	err := http.ListenAndServe(fmt.Sprintf(":%d", config.Server().Port), router)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// newRouter create the router of the API, every route must be described in docs/openapi.yaml
+func newRouter() *chi.Mux {
 	router := chi.NewRouter()
 	router.Use(middleware.Logger)
 	router.Use(recovery)
 	router.Route("/api", func(rApi chi.Router) {
+		rApi.Get("/openapi.yaml", OpenApiYaml)
+		rApi.Get("/openapi.json", OpenApiJson)
+		rApi.Get("/docs", ApiDocs)
 		rApi.Route("/v1", func(r chi.Router) {
 			r.Post("/login", Login)
+			r.Post("/login/totp", LoginTotp)
 			r.Post("/check/token", CheckToken)
-			if config.Features().AllowRegister {
+			r.Post("/refresh", Refresh)
+			r.Post("/password/reset", ResetPassword)
+			r.Get("/link/{token}", DownloadShareLink)
+			r.Post("/link/{token}", DownloadShareLink)
+			r.Route("/device", func(deviceRouter chi.Router) {
+				deviceRouter.Post("/code", DeviceAuthorize)
+				deviceRouter.Post("/token", DeviceToken)
+			})
+			if config.Features().AllowRegister || config.Features().RequireInvitation {
 				r.Post("/register", Register)
 			}
+			if config.Authentication().Oidc.Enabled {
+				r.Route("/oidc", func(oidcRouter chi.Router) {
+					oidcRouter.Post("/authorize", OidcAuthorize)
+					oidcRouter.Get("/callback", OidcCallback)
+					oidcRouter.Post("/confirm", OidcConfirm)
+					oidcRouter.Post("/token", OidcToken)
+				})
+			}
 			r.Route("/system", func(systemRouter chi.Router) {
 				systemRouter.Get("/information", Information)
 			})
 			r.Route("/admin", func(adminRouter chi.Router) {
 				adminRouter.Use(adminMiddleware)
-				adminRouter.Post("/user", AddUser)
-				adminRouter.Post("/user/username", ChangeUsername)
-				adminRouter.Post("/user/passwd/{id}", ChangeUserPassword)
-				adminRouter.Delete("/user/{id}", RemoveUser)
-				adminRouter.Get("/user/{id}", User)
-				adminRouter.Get("/users", AllUsers)
-				adminRouter.Get("/user/role/admin/{id}", SetAdmin)
-				adminRouter.Get("/user/role/user/{id}", SetNotAdmin)
+				adminRouter.With(permissionMiddleware(database.PermissionUsersWrite)).Post("/user", AddUser)
+				adminRouter.With(permissionMiddleware(database.PermissionUsersWrite)).Post("/user/username", ChangeUsername)
+				adminRouter.With(permissionMiddleware(database.PermissionUsersWrite)).Post("/user/passwd/{id}", ChangeUserPassword)
+				adminRouter.With(permissionMiddleware(database.PermissionUsersWrite)).Delete("/user/{id}", RemoveUser)
+				adminRouter.With(permissionMiddleware(database.PermissionUsersRead)).Get("/user/{id}", User)
+				adminRouter.With(permissionMiddleware(database.PermissionUsersRead)).Get("/users", AllUsers)
+				adminRouter.With(permissionMiddleware(database.PermissionRoles)).Get("/user/role/admin/{id}", SetAdmin)
+				adminRouter.With(permissionMiddleware(database.PermissionRoles)).Get("/user/role/user/{id}", SetNotAdmin)
+				adminRouter.With(permissionMiddleware(database.PermissionRoles)).Post("/user/role", SetUserRole)
+				adminRouter.With(permissionMiddleware(database.PermissionUsersRead)).Get("/roles", Roles)
+				adminRouter.With(permissionMiddleware(database.PermissionRoles)).Post("/role", SaveRole)
+				adminRouter.With(permissionMiddleware(database.PermissionRoles)).Delete("/role/{name}", RemoveRole)
+				adminRouter.With(permissionMiddleware(database.PermissionSessions)).Get("/user/sessions/{id}", UserSessions)
+				adminRouter.With(permissionMiddleware(database.PermissionSessions)).Delete("/session/{id}", RemoveSession)
+				adminRouter.With(permissionMiddleware(database.PermissionSecurity)).Delete("/user/totp/{id}", ResetTotp)
+				adminRouter.With(permissionMiddleware(database.PermissionSecurity)).Get("/lockouts", Lockouts)
+				adminRouter.With(permissionMiddleware(database.PermissionSecurity)).Delete("/lockout/user/{username}", ClearUserLockout)
+				adminRouter.With(permissionMiddleware(database.PermissionSecurity)).Delete("/lockout/ip/{ip}", ClearIpLockout)
+				adminRouter.With(permissionMiddleware(database.PermissionSecurity)).Post("/user/reset/{id}", CreatePasswordReset)
+				adminRouter.With(permissionMiddleware(database.PermissionInvitations)).Post("/invitation", CreateInvitation)
+				adminRouter.With(permissionMiddleware(database.PermissionInvitations)).Get("/invitations", Invitations)
+				adminRouter.With(permissionMiddleware(database.PermissionInvitations)).Delete("/invitation/{id}", RevokeInvitation)
+				adminRouter.With(permissionMiddleware(database.PermissionAudit)).Get("/audit", AuditEvents)
+				adminRouter.With(permissionMiddleware(database.PermissionCatalog)).Post("/catalog/import", ImportCatalog)
+				adminRouter.With(permissionMiddleware(database.PermissionCatalog)).Post("/catalog/ludusavi", ImportLudusavi)
+				adminRouter.With(permissionMiddleware(database.PermissionCatalog)).Post("/catalog", SaveCatalogGame)
+				adminRouter.With(permissionMiddleware(database.PermissionCatalog)).Delete("/catalog/{id}", RemoveCatalogGame)
+				adminRouter.With(permissionMiddleware(database.PermissionStorage)).Get("/user/storage/{id}", UserStorage)
+				adminRouter.With(permissionMiddleware(database.PermissionStorage)).Post("/user/quota", SetStorageQuota)
 			})
 			r.Group(func(secureRouter chi.Router) {
 				secureRouter.Use(authMiddleware)
 				secureRouter.Route("/user", func(userRouter chi.Router) {
-					userRouter.Get("/information", UserInformation)
-					userRouter.Post("/passwd", ChangePassword)
+					userRouter.With(scopeMiddleware(authentication.ScopeRead)).Get("/information", UserInformation)
+					userRouter.Group(func(sessionRouter chi.Router) {
+						sessionRouter.Use(sessionMiddleware)
+						sessionRouter.Post("/passwd", ChangePassword)
+						sessionRouter.Post("/logout", Logout)
+						sessionRouter.Get("/sessions", Sessions)
+						sessionRouter.Delete("/session/{id}", RevokeSession)
+						sessionRouter.Post("/token", CreatePersonalAccessToken)
+						sessionRouter.Get("/tokens", PersonalAccessTokens)
+						sessionRouter.Delete("/token/{id}", RevokePersonalAccessToken)
+						sessionRouter.Post("/totp/enroll", EnrollTotp)
+						sessionRouter.Post("/totp/confirm", ConfirmTotp)
+						sessionRouter.Post("/totp/disable", DisableTotp)
+						sessionRouter.Post("/totp/recovery", RecoveryCodes)
+						sessionRouter.Get("/device/{code}", PendingDevice)
+						sessionRouter.Post("/device/approve", ApproveDevice)
+						sessionRouter.Post("/device/deny", DenyDevice)
+					})
+				})
+				secureRouter.Route("/catalog", func(catalogRouter chi.Router) {
+					catalogRouter.Use(scopeMiddleware(authentication.ScopeRead))
+					catalogRouter.Get("/search", SearchCatalog)
+					catalogRouter.Get("/{id}", CatalogGame)
+					catalogRouter.Get("/{id}/paths", CatalogSavePaths)
+				})
+				secureRouter.Route("/group", func(groupRouter chi.Router) {
+					groupRouter.With(scopeMiddleware(authentication.ScopeUpload)).Post("/create", CreateGroup)
+					groupRouter.With(scopeMiddleware(authentication.ScopeRead)).Get("/all", Groups)
+					groupRouter.With(scopeMiddleware(authentication.ScopeRead)).Get("/{id}", GroupInfo)
+					groupRouter.With(scopeMiddleware(authentication.ScopeManage)).Delete("/{id}", RemoveGroup)
+					groupRouter.With(scopeMiddleware(authentication.ScopeManage)).Post("/{id}/member", SaveGroupMember)
+					groupRouter.With(scopeMiddleware(authentication.ScopeManage)).Delete("/{id}/member/{userId}", RemoveGroupMember)
+					groupRouter.With(scopeMiddleware(authentication.ScopeManage)).Delete("/{id}/game/{gameId}", RemoveGroupGame)
 				})
 				secureRouter.Route("/game", func(gameRouter chi.Router) {
-					gameRouter.Post("/create", CreateGame)
-					gameRouter.Get("/all", AllGamesInformation)
-					gameRouter.Delete("/remove/{id}", RemoveGame)
-					gameRouter.Get("/info/{id}", GameInfoByID)
+					gameRouter.With(scopeMiddleware(authentication.ScopeUpload)).Post("/create", CreateGame)
+					gameRouter.With(scopeMiddleware(authentication.ScopeRead)).Get("/all", AllGamesInformation)
+					gameRouter.With(scopeMiddleware(authentication.ScopeManage)).Delete("/remove/{id}", RemoveGame)
+					gameRouter.With(scopeMiddleware(authentication.ScopeRead)).Get("/info/{id}", GameInfoByID)
+					gameRouter.With(scopeMiddleware(authentication.ScopeManage)).Post("/share", ShareGame)
+					gameRouter.With(scopeMiddleware(authentication.ScopeRead)).Get("/shares/{id}", GameShares)
+					gameRouter.With(scopeMiddleware(authentication.ScopeManage)).Delete("/share/{id}/{userId}", RemoveGameShare)
+					gameRouter.With(scopeMiddleware(authentication.ScopeManage)).Post("/link", CreateShareLink)
+					gameRouter.With(scopeMiddleware(authentication.ScopeManage)).Get("/links", ShareLinks)
+					gameRouter.With(scopeMiddleware(authentication.ScopeManage)).Delete("/link/{id}", RevokeShareLink)
+					gameRouter.With(scopeMiddleware(authentication.ScopeManage)).Get("/{id}/links", GameShareLinks)
+					gameRouter.With(scopeMiddleware(authentication.ScopeUpload)).Post("/revision", UpdateRevision)
+					gameRouter.With(scopeMiddleware(authentication.ScopeUpload)).Post("/update", UpdateGame)
+					gameRouter.With(scopeMiddleware(authentication.ScopeUpload)).Post("/move", MoveSave)
+					gameRouter.With(scopeMiddleware(authentication.ScopeRead)).Get("/paths/variables", TemplateVariables)
+					gameRouter.With(scopeMiddleware(authentication.ScopeRead)).Get("/{id}/paths", SavePathTemplates)
+					gameRouter.With(scopeMiddleware(authentication.ScopeUpload)).Post("/paths", SetSavePathTemplates)
+					gameRouter.With(scopeMiddleware(authentication.ScopeUpload)).Post("/{id}/screenshot", UploadScreenshot)
+					gameRouter.With(scopeMiddleware(authentication.ScopeRead)).Get("/{id}/screenshot", Screenshot)
+					gameRouter.With(scopeMiddleware(authentication.ScopeUpload)).Delete("/{id}/screenshot", RemoveScreenshot)
 					gameRouter.Post("/upload/init", AskForUpload)
-					gameRouter.Group(func(uploadRouter chi.Router) {
-						uploadRouter.Use(uploadMiddleware)
-						uploadRouter.Post("/upload", UploadSave)
-						uploadRouter.Get("/download", Download)
-					})
+					gameRouter.With(uploadMiddleware, gameScopeMiddleware(authentication.ScopeUpload)).Post("/upload", UploadSave)
+					gameRouter.With(downloadMiddleware, gameScopeMiddleware(authentication.ScopeRead)).Get("/download", Download)
 				})
 			})
 		})
 	})
-	log.Println("Server is listening...")
-	err := http.ListenAndServe(fmt.Sprintf(":%d", config.Server().Port), router)
-	if err != nil {
-		log.Fatal(err)
-	}
+	return router
 }
 
 // authMiddleware check the authentication token before accessing to the resource
@@ -80,13 +174,12 @@ func authMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		header := r.Header.Get("Authorization")
 		if len(header) > 7 {
-			userId, err := authentication.ParseToken(header[7:])
+			info, err := authentication.Authenticate(header[7:])
 			if err != nil {
 				unauthorized(w, r)
 				return
 			}
-			ctx := context.WithValue(r.Context(), UserIdKey, userId)
-			r = r.WithContext(ctx)
+			r = r.WithContext(tokenContext(r.Context(), info))
 			next.ServeHTTP(w, r)
 			return
 		}
@@ -94,27 +187,32 @@ func authMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-// adminMiddleware check the role of the user before accessing to the resource
+// adminMiddleware check that the role of the user give at least one permission before accessing to the resource
 func adminMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		header := r.Header.Get("Authorization")
 		if len(header) > 7 {
-			userId, err := authentication.ParseToken(header[7:])
+			info, err := authentication.Authenticate(header[7:])
 			if err != nil {
 				unauthorized(w, r)
 				return
 			}
-			user, err := database.UserById(userId)
+			if !info.HasScope(authentication.ScopeAdmin, 0) {
+				forbidden(w, r)
+				return
+			}
+			user, err := database.UserById(info.UserId)
 			if err != nil {
 				internalServerError(w, r)
 				log.Println(err)
 				return
 			}
-			if !user.IsAdmin {
+			role, err := database.RoleByName(user.Role)
+			if err != nil || len(role.PermissionList) == 0 {
 				forbidden(w, r)
 				return
 			}
-			ctx := context.WithValue(r.Context(), UserIdKey, userId)
+			ctx := context.WithValue(tokenContext(r.Context(), info), RoleKey, role)
 			r = r.WithContext(ctx)
 			next.ServeHTTP(w, r)
 			return
@@ -123,6 +221,86 @@ func adminMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// permissionMiddleware check that the role of the user give the permission to access to the resource
+func permissionMiddleware(permission string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			role, ok := r.Context().Value(RoleKey).(*database.Role)
+			if !ok {
+				internalServerError(w, r)
+				log.Println(errors.New("role not found in context"))
+				return
+			}
+			if !role.HasPermission(permission) {
+				forbidden(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// sessionMiddleware forbid the personal access tokens, the resource is only available from a session
+func sessionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info, err := tokenFromContext(r.Context())
+		if err != nil {
+			internalServerError(w, r)
+			log.Println(err)
+			return
+		}
+		if len(info.SessionId) == 0 {
+			forbidden(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// scopeMiddleware check that the personal access token have the scope to access to the resource
+func scopeMiddleware(scope string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			info, err := tokenFromContext(r.Context())
+			if err != nil {
+				internalServerError(w, r)
+				log.Println(err)
+				return
+			}
+			if !info.HasScope(scope, 0) {
+				forbidden(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// gameScopeMiddleware check that the personal access token have the scope to access to the game of the upload key
+func gameScopeMiddleware(scope string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			info, err := tokenFromContext(r.Context())
+			if err != nil {
+				internalServerError(w, r)
+				log.Println(err)
+				return
+			}
+			gameId, err := gameIdFromContext(r.Context())
+			if err != nil {
+				internalServerError(w, r)
+				log.Println(err)
+				return
+			}
+			if !info.HasScope(scope, gameId) {
+				forbidden(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 // uploadMiddleware check the upload key before allowing to upload a file
 func uploadMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -139,6 +317,22 @@ func uploadMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// downloadMiddleware check the upload key or the download key before allowing to download a file
+func downloadMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("X-Upload-Key")
+		if len(header) > 0 {
+			if gameId, ok := upload.CheckDownloadToken(header); ok {
+				ctx := context.WithValue(r.Context(), GameIdKey, gameId)
+				r = r.WithContext(ctx)
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		unauthorized(w, r)
+	})
+}
+
 func userIdFromContext(ctx context.Context) (int, error) {
 	if userId, ok := ctx.Value(UserIdKey).(int); ok {
 		return userId, nil
@@ -146,6 +340,27 @@ func userIdFromContext(ctx context.Context) (int, error) {
 	return 0, errors.New("userId not found in context")
 }
 
+// tokenContext add the information of the authentication token to the context
+func tokenContext(ctx context.Context, info *authentication.TokenInfo) context.Context {
+	ctx = context.WithValue(ctx, UserIdKey, info.UserId)
+	ctx = context.WithValue(ctx, SessionIdKey, info.SessionId)
+	return context.WithValue(ctx, TokenKey, info)
+}
+
+func tokenFromContext(ctx context.Context) (*authentication.TokenInfo, error) {
+	if info, ok := ctx.Value(TokenKey).(*authentication.TokenInfo); ok {
+		return info, nil
+	}
+	return nil, errors.New("token not found in context")
+}
+
+func sessionIdFromContext(ctx context.Context) (string, error) {
+	if sessionId, ok := ctx.Value(SessionIdKey).(string); ok {
+		return sessionId, nil
+	}
+	return "", errors.New("sessionId not found in context")
+}
+
 func gameIdFromContext(ctx context.Context) (int, error) {
 	if gameId, ok := ctx.Value(GameIdKey).(int); ok {
 		return gameId, nil
@@ -8,22 +8,26 @@ import (
 )
 
 type information struct {
-	AllowRegister  bool   `json:"allow_register"`
-	Version        string `json:"version"`
-	ApiVersion     int    `json:"api_version"`
-	GoVersion      string `json:"go_version"`
-	OsName         string `json:"os_name"`
-	OsArchitecture string `json:"os_architecture"`
+	AllowRegister     bool   `json:"allow_register"`
+	RequireInvitation bool   `json:"require_invitation"`
+	OidcEnabled       bool   `json:"oidc_enabled"`
+	Version           string `json:"version"`
+	ApiVersion        int    `json:"api_version"`
+	GoVersion         string `json:"go_version"`
+	OsName            string `json:"os_name"`
+	OsArchitecture    string `json:"os_architecture"`
 }
 
 func Information(w http.ResponseWriter, r *http.Request) {
 	info := information{
-		AllowRegister:  config.Features().AllowRegister,
-		Version:        constant.Version,
-		ApiVersion:     constant.ApiVersion,
-		GoVersion:      runtime.Version(),
-		OsName:         runtime.GOOS,
-		OsArchitecture: runtime.GOARCH,
+		AllowRegister:     config.Features().AllowRegister,
+		RequireInvitation: config.Features().RequireInvitation,
+		OidcEnabled:       config.Authentication().Oidc.Enabled,
+		Version:           constant.Version,
+		ApiVersion:        constant.ApiVersion,
+		GoVersion:         runtime.Version(),
+		OsName:            runtime.GOOS,
+		OsArchitecture:    runtime.GOARCH,
 	}
 	ok(info, w, r)
 }
@@ -0,0 +1,186 @@
+package upload
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"opensavecloudserver/config"
+	"opensavecloudserver/database"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	ThumbnailWidth  = 320
+	ThumbnailHeight = 180
+	// maxScreenshotSize is the maximum size of the uploaded file, in bytes
+	maxScreenshotSize = 10 << 20
+	// maxScreenshotPixels protect the server from the images that are small files but huge once decoded
+	maxScreenshotPixels = 4096 * 4096
+)
+
+// DecodeScreenshot read and validate a PNG or JPEG screenshot
+func DecodeScreenshot(r io.Reader) (image.Image, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxScreenshotSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxScreenshotSize {
+		return nil, fmt.Errorf("the screenshot cannot be bigger than %d MB", maxScreenshotSize>>20)
+	}
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.New("the screenshot must be a PNG or a JPEG image")
+	}
+	if format != "png" && format != "jpeg" {
+		return nil, errors.New("the screenshot must be a PNG or a JPEG image")
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > maxScreenshotPixels {
+		return nil, errors.New("the dimensions of the screenshot are not valid")
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.New("the screenshot cannot be decoded")
+	}
+	return img, nil
+}
+
+// SaveScreenshot store the screenshot and its thumbnail next to the archive of the game, a revision of 0
+// is the screenshot of the game itself. The previous screenshot of the same revision is replaced
+func SaveScreenshot(game *database.Game, revision int, img image.Image) (*database.Screenshot, error) {
+	folder := path.Join(config.Path().Storage, storageFolder(game))
+	if _, err := os.Stat(folder); err != nil {
+		err = os.Mkdir(folder, 0766)
+		if err != nil {
+			return nil, err
+		}
+	}
+	screenshot := &database.Screenshot{
+		GameId:    game.ID,
+		Revision:  revision,
+		Name:      screenshotName(game, revision),
+		Width:     img.Bounds().Dx(),
+		Height:    img.Bounds().Dy(),
+		CreatedAt: time.Now(),
+	}
+	if err := writeJpeg(ScreenshotPath(game, screenshot, false), img, 90); err != nil {
+		return nil, err
+	}
+	if err := writeJpeg(ScreenshotPath(game, screenshot, true), thumbnail(img), 85); err != nil {
+		return nil, err
+	}
+	if err := database.SaveScreenshot(screenshot); err != nil {
+		return nil, err
+	}
+	return screenshot, nil
+}
+
+// screenshotName give the base name of the files of a screenshot of the game
+func screenshotName(game *database.Game, revision int) string {
+	name := strings.TrimSuffix(game.PathStorage, ".bin")
+	if revision > 0 {
+		name = fmt.Sprintf("%s.r%d", name, revision)
+	}
+	return name
+}
+
+// moveScreenshot give the screenshot of a game to a revision of another game, its files are renamed
+func moveScreenshot(from, to *database.Game, screenshot *database.Screenshot, revision int) error {
+	folder := path.Join(config.Path().Storage, storageFolder(to))
+	if err := os.MkdirAll(folder, 0766); err != nil {
+		return err
+	}
+	moved := *screenshot
+	moved.GameId = to.ID
+	moved.Revision = revision
+	moved.Name = screenshotName(to, revision)
+	for _, thumbnail := range []bool{false, true} {
+		if err := os.Rename(ScreenshotPath(from, screenshot, thumbnail), ScreenshotPath(to, &moved, thumbnail)); err != nil {
+			return err
+		}
+	}
+	*screenshot = moved
+	return database.SaveScreenshot(screenshot)
+}
+
+// ScreenshotPath give the path of the screenshot or of its thumbnail in the storage
+func ScreenshotPath(game *database.Game, screenshot *database.Screenshot, thumbnail bool) string {
+	suffix := ".screenshot.jpg"
+	if thumbnail {
+		suffix = ".thumbnail.jpg"
+	}
+	return path.Join(config.Path().Storage, storageFolder(game), screenshot.Name+suffix)
+}
+
+// RemoveScreenshot remove the files of the screenshot
+func RemoveScreenshot(game *database.Game, screenshot *database.Screenshot) error {
+	for _, thumbnail := range []bool{false, true} {
+		err := os.Remove(ScreenshotPath(game, screenshot, thumbnail))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeJpeg(filePath string, img image.Image, quality int) error {
+	f, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	if err := jpeg.Encode(f, img, &jpeg.Options{Quality: quality}); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// thumbnail crop the center of the image to the ratio of the thumbnail, then scale it down by averaging
+// the source pixels of each destination pixel. The pixels are read from the decoded image, without a copy
+func thumbnail(img image.Image) image.Image {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	cropW, cropH := w, w*ThumbnailHeight/ThumbnailWidth
+	if cropH > h {
+		cropW, cropH = h*ThumbnailWidth/ThumbnailHeight, h
+	}
+	if cropW == 0 || cropH == 0 {
+		cropW, cropH = w, h
+	}
+	offsetX, offsetY := bounds.Min.X+(w-cropW)/2, bounds.Min.Y+(h-cropH)/2
+	dst := image.NewRGBA(image.Rect(0, 0, ThumbnailWidth, ThumbnailHeight))
+	for y := 0; y < ThumbnailHeight; y++ {
+		y0 := offsetY + y*cropH/ThumbnailHeight
+		y1 := offsetY + (y+1)*cropH/ThumbnailHeight
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < ThumbnailWidth; x++ {
+			x0 := offsetX + x*cropW/ThumbnailWidth
+			x1 := offsetX + (x+1)*cropW/ThumbnailWidth
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r += uint64(cr)
+					g += uint64(cg)
+					b += uint64(cb)
+					a += uint64(ca)
+					n++
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{R: uint8(r / n >> 8), G: uint8(g / n >> 8), B: uint8(b / n >> 8), A: uint8(a / n >> 8)})
+		}
+	}
+	return dst
+}
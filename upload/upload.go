@@ -17,9 +17,20 @@ import (
 	"time"
 )
 
+const linkFolder = "links"
+
+// ErrQuotaExceeded is given when the archive does not fit in the storage quota of the owner of the game
+var ErrQuotaExceeded = errors.New("the storage quota of the owner of the game is exceeded")
+
+// ErrReadOnlyGame is returned when a user who can only read a game ask for an upload lock
+var ErrReadOnlyGame = errors.New("this game can only be read, it cannot be uploaded")
+
+const downloadTokenLifetime = 10 * time.Minute
+
 var (
-	locks map[int]GameUploadToken
-	mu    sync.Mutex
+	locks     map[int]GameUploadToken
+	downloads map[string]GameUploadToken
+	mu        sync.Mutex
 )
 
 type GameUploadToken struct {
@@ -30,6 +41,7 @@ type GameUploadToken struct {
 
 func init() {
 	locks = make(map[int]GameUploadToken)
+	downloads = make(map[string]GameUploadToken)
 	go func() {
 		for {
 			time.Sleep(time.Minute)
@@ -77,14 +89,17 @@ func MoveFile(sourcePath, destPath string) error {
 	return nil
 }
 
-// AskForUpload Create a lock for upload a new revision of a game
+// AskForUpload Create a lock for upload a new revision of a game, the user must be able to write the game
 func AskForUpload(userId, gameId int) (*GameUploadToken, error) {
 	mu.Lock()
 	defer mu.Unlock()
-	_, err := database.GameInfoById(userId, gameId)
+	game, err := database.AccessibleGameById(userId, gameId)
 	if err != nil {
 		return nil, err
 	}
+	if game.ShareLevel == database.ShareRead {
+		return nil, ErrReadOnlyGame
+	}
 	if _, ok := locks[gameId]; !ok {
 		token := uuid.New()
 		lock := GameUploadToken{
@@ -108,8 +123,50 @@ func CheckUploadToken(uploadToken string) (int, bool) {
 	return -1, false
 }
 
+// AskForDownload create a token to download the save of a game. The game is not locked, so a user who can
+// only read the game cannot block the uploads of the users who can write it
+func AskForDownload(userId, gameId int) (*GameUploadToken, error) {
+	if _, err := database.AccessibleGameById(userId, gameId); err != nil {
+		return nil, err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	token := GameUploadToken{
+		GameId:      gameId,
+		UploadToken: uuid.New().String(),
+		Expire:      time.Now().Add(downloadTokenLifetime),
+	}
+	downloads[token.UploadToken] = token
+	return &token, nil
+}
+
+// CheckDownloadToken give the game of an upload lock or of a download token
+func CheckDownloadToken(uploadToken string) (int, bool) {
+	if gameId, ok := CheckUploadToken(uploadToken); ok {
+		return gameId, true
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if token, ok := downloads[uploadToken]; ok {
+		return token.GameId, true
+	}
+	return -1, false
+}
+
+// ReleaseToken remove the download token, or the lock of the game when the token is an upload lock
+func ReleaseToken(uploadToken string) {
+	mu.Lock()
+	defer mu.Unlock()
+	delete(downloads, uploadToken)
+	for gameId, lock := range locks {
+		if lock.UploadToken == uploadToken {
+			delete(locks, gameId)
+		}
+	}
+}
+
 func UploadToCache(file multipart.File, game *database.Game) error {
-	filePath := path.Join(config.Path().Cache, strconv.Itoa(game.UserId))
+	filePath := path.Join(config.Path().Cache, storageFolder(game))
 	if _, err := os.Stat(filePath); err != nil {
 		err = os.Mkdir(filePath, 0766)
 		if err != nil {
@@ -134,7 +191,7 @@ func UploadToCache(file multipart.File, game *database.Game) error {
 }
 
 func ValidateAndMove(game *database.Game, hash string) error {
-	filePath := path.Join(config.Path().Cache, strconv.Itoa(game.UserId), game.PathStorage)
+	filePath := path.Join(config.Path().Cache, storageFolder(game), game.PathStorage)
 	if err := checkHash(filePath, hash); err != nil {
 		return err
 	}
@@ -156,7 +213,7 @@ func checkHash(path, hash string) error {
 }
 
 func moveToStorage(cachePath string, game *database.Game) error {
-	filePath := path.Join(config.Path().Storage, strconv.Itoa(game.UserId))
+	filePath := path.Join(config.Path().Storage, storageFolder(game))
 	if _, err := os.Stat(filePath); err != nil {
 		err = os.Mkdir(filePath, 0766)
 		if err != nil {
@@ -175,6 +232,104 @@ func moveToStorage(cachePath string, game *database.Game) error {
 	return nil
 }
 
+// MoveSave move the save archive of a game to another game, the archive becomes a new revision of the
+// destination and the source does not have a save anymore. Both games are locked during the move
+func MoveSave(from, to *database.Game, revision *database.Revision) error {
+	if !from.Available || from.Hash == nil {
+		return errors.New("the game does not have a save to move")
+	}
+	if !lockGame(from.ID) {
+		return errors.New("game already locked")
+	}
+	defer UnlockGame(from.ID)
+	if !lockGame(to.ID) {
+		return errors.New("game already locked")
+	}
+	defer UnlockGame(to.ID)
+	if err := checkMoveQuota(from, to); err != nil {
+		return err
+	}
+	if err := moveToStorage(SavePath(from), to); err != nil {
+		return err
+	}
+	if err := database.UpdateGameRevision(to, *from.Hash, revision); err != nil {
+		return err
+	}
+	return database.ClearGameSave(from)
+}
+
+// MergeSave give the save and the history of a game to another game before the source is removed. The revisions
+// of the source are added after the revisions of the destination and its screenshots follow their revision,
+// the screenshot of the game is kept only when the destination does not have one. When the current save of the
+// source does not have a revision, the given revision is added for it. Both games are locked during the merge
+func MergeSave(from, to *database.Game, revision *database.Revision) error {
+	if !lockGame(from.ID) {
+		return errors.New("game already locked")
+	}
+	defer UnlockGame(from.ID)
+	if !lockGame(to.ID) {
+		return errors.New("game already locked")
+	}
+	defer UnlockGame(to.ID)
+	revisions, err := database.RevisionsByGameId(from.ID)
+	if err != nil {
+		return err
+	}
+	if from.Available && from.Hash != nil {
+		if len(revisions) == 0 || revisions[0].Revision != from.Revision {
+			revision.Revision = from.Revision
+			revision.Hash = *from.Hash
+			revision.CreatedAt = time.Now()
+			revisions = append(revisions, revision)
+		}
+		if err := checkMoveQuota(from, to); err != nil {
+			return err
+		}
+		if err := moveToStorage(SavePath(from), to); err != nil {
+			return err
+		}
+	} else if len(revisions) > 0 {
+		return errors.New("the game has revisions but no save, its history cannot be merged")
+	}
+	numbers, err := database.MergeGameHistory(from, to, revisions)
+	if err != nil {
+		return err
+	}
+	screenshots, err := database.ScreenshotsByGameId(from.ID)
+	if err != nil {
+		return err
+	}
+	for _, screenshot := range screenshots {
+		number, ok := numbers[screenshot.Revision]
+		if screenshot.Revision == 0 {
+			_, err := database.ScreenshotByRevision(to.ID, 0)
+			ok = err != nil
+		}
+		if !ok {
+			// The source is removed after the merge with the screenshots that are not moved
+			continue
+		}
+		if err := moveScreenshot(from, to, screenshot, number); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lockGame lock a game for an operation of the server, it returns false if the game is already locked
+func lockGame(gameId int) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := locks[gameId]; ok {
+		return false
+	}
+	locks[gameId] = GameUploadToken{
+		GameId:      gameId,
+		UploadToken: uuid.New().String(),
+	}
+	return true
+}
+
 func UnlockGame(gameId int) {
 	mu.Lock()
 	defer mu.Unlock()
@@ -200,9 +355,185 @@ func RemoveFolders(userId int) error {
 	return nil
 }
 
-func RemoveGame(userId int, game *database.Game) error {
-	filePath := path.Join(config.Path().Storage, strconv.Itoa(userId), game.PathStorage)
-	return os.Remove(filePath)
+// RemoveGame remove the save archive, the screenshots and the snapshots of the share links of the game
+// from the storage
+func RemoveGame(game *database.Game) error {
+	screenshots, err := database.ScreenshotsByGameId(game.ID)
+	if err != nil {
+		return err
+	}
+	for _, screenshot := range screenshots {
+		if err := RemoveScreenshot(game, screenshot); err != nil {
+			return err
+		}
+	}
+	links, err := database.ShareLinksByGameId(game.ID)
+	if err != nil {
+		return err
+	}
+	if err := RemoveLinkSnapshots(links); err != nil {
+		return err
+	}
+	err = os.Remove(SavePath(game))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// SavePath give the path of the game save archive in the storage
+func SavePath(game *database.Game) string {
+	return path.Join(config.Path().Storage, storageFolder(game), game.PathStorage)
+}
+
+// RemoveGroupFolders remove all files of the group from storage and cache
+func RemoveGroupFolders(groupId int) error {
+	folder := groupFolder(groupId)
+	if err := os.RemoveAll(path.Join(config.Path().Storage, folder)); err != nil {
+		return err
+	}
+	return os.RemoveAll(path.Join(config.Path().Cache, folder))
+}
+
+// GroupStorageUsage give the size in bytes of the game saves of the group
+func GroupStorageUsage(groupId int) (int64, error) {
+	return folderSize(groupFolder(groupId))
+}
+
+// UserStorageUsage give the size in bytes of the game saves of the user, without the games of their groups
+func UserStorageUsage(userId int) (int64, error) {
+	return folderSize(strconv.Itoa(userId))
+}
+
+// CheckQuota refuse an archive of the given size when it makes the owner of the game go over their quota.
+// The current archive of the game is replaced, so its size is not counted. The games of a group have no quota
+func CheckQuota(game *database.Game, size int64) error {
+	if game.GroupId != nil {
+		return nil
+	}
+	owner, err := database.UserById(game.UserId)
+	if err != nil {
+		return err
+	}
+	if owner.StorageQuota == nil {
+		return nil
+	}
+	used, err := UserStorageUsage(owner.ID)
+	if err != nil {
+		return err
+	}
+	if info, err := os.Stat(SavePath(game)); err == nil {
+		used -= info.Size()
+	}
+	if used+size > *owner.StorageQuota {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// checkMoveQuota check the quota of the owner of the destination when the save goes to the storage of another owner
+func checkMoveQuota(from, to *database.Game) error {
+	if storageFolder(from) == storageFolder(to) {
+		return nil
+	}
+	info, err := os.Stat(SavePath(from))
+	if err != nil {
+		return err
+	}
+	return CheckQuota(to, info.Size())
+}
+
+func folderSize(folder string) (int64, error) {
+	entries, err := os.ReadDir(path.Join(config.Path().Storage, folder))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	var size int64
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			return 0, err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+	}
+	return size, nil
+}
+
+// SnapshotSave copy the current archive of the game to the folder of the share links, so the link
+// keep the revision even after a new upload. The name of the snapshot is returned
+func SnapshotSave(game *database.Game) (string, error) {
+	folder := path.Join(config.Path().Storage, linkFolder)
+	if _, err := os.Stat(folder); err != nil {
+		err = os.Mkdir(folder, 0766)
+		if err != nil {
+			return "", err
+		}
+	}
+	name := uuid.New().String() + ".bin"
+	source, err := os.Open(SavePath(game))
+	if err != nil {
+		return "", err
+	}
+	defer func(source *os.File) {
+		err := source.Close()
+		if err != nil {
+			log.Println(err)
+		}
+	}(source)
+	dest, err := os.Create(path.Join(folder, name))
+	if err != nil {
+		return "", err
+	}
+	defer func(dest *os.File) {
+		err := dest.Close()
+		if err != nil {
+			log.Println(err)
+		}
+	}(dest)
+	if _, err := io.Copy(dest, source); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// LinkPath give the path of the snapshot of a share link
+func LinkPath(link *database.ShareLink) string {
+	return path.Join(config.Path().Storage, linkFolder, link.PathStorage)
+}
+
+func RemoveLinkSnapshot(link *database.ShareLink) error {
+	err := os.Remove(LinkPath(link))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// RemoveLinkSnapshots remove the snapshots of the share links, the links must then be removed from the database
+func RemoveLinkSnapshots(links []*database.ShareLink) error {
+	for _, link := range links {
+		if err := RemoveLinkSnapshot(link); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// storageFolder give the folder of the owner of the game, a user or a group
+func storageFolder(game *database.Game) string {
+	if game.GroupId != nil {
+		return groupFolder(*game.GroupId)
+	}
+	return strconv.Itoa(game.UserId)
+}
+
+func groupFolder(groupId int) string {
+	return "group-" + strconv.Itoa(groupId)
 }
 
 func FileHash(path string) (string, error) {
@@ -237,4 +568,9 @@ func clearLocks() {
 	for _, gameId := range toUnlock {
 		delete(locks, gameId)
 	}
+	for uploadToken, token := range downloads {
+		if token.Expire.Before(now) {
+			delete(downloads, uploadToken)
+		}
+	}
 }